@@ -0,0 +1,497 @@
+package rateLimiter
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// AuditRecord describes a request that skipped rate limiting because of a bypass token
+// or a whitelisted IP.
+type AuditRecord struct {
+	// Time is when the request was handled, according to RateLimiterConfig.Clock
+	Time time.Time `json:"time"`
+
+	// Reason is ReasonBypassToken or ReasonWhitelisted
+	Reason DecisionReason `json:"reason"`
+
+	// TokenID identifies the bypass token used (see AuditLog.TokenID), empty for whitelisted IPs
+	TokenID string `json:"token_id,omitempty"`
+
+	// IP is the client IP of the request
+	IP string `json:"ip"`
+
+	// Method is the request's HTTP method
+	Method string `json:"method"`
+
+	// Route is the RoutePolicy path the request matched, or else the route path as
+	// registered with Fiber, which under app.Use is the middleware's own
+	Route string `json:"route"`
+
+	// Path is the request path
+	Path string `json:"path"`
+}
+
+// AuditSink stores audit records. Implementations must be safe for concurrent use.
+// An AuditLog calls Audit from a background goroutine, one record at a time, with a
+// context bounded by AuditConfig.Timeout; requests don't wait for it.
+type AuditSink interface {
+	Audit(ctx context.Context, record AuditRecord) error
+}
+
+// minTokenKeyLen is the minimum length of AuditConfig.TokenKey.
+const minTokenKeyLen = 16
+
+// BypassTokenID returns the identifier of a bypass token recorded in audit records:
+// the first 16 hex digits of its HMAC-SHA256 keyed with key. The token itself is never
+// recorded, and without the key its ID can't be used to check guesses of it.
+func BypassTokenID(key []byte, token string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// AuditConfig defines how an AuditLog identifies bypass tokens and writes to its
+// sinks. Zero values are replaced with the defaults noted on each field.
+type AuditConfig struct {
+	// TokenKey is the secret key bypass tokens are identified with (see BypassTokenID).
+	// Required, at least 16 bytes. Use the same key on every instance and across
+	// restarts, so a token keeps its ID.
+	TokenKey []byte
+
+	// Buffer is the maximum number of records waiting to be written to the sinks.
+	// Records of bypasses seen while it is full are dropped and counted in
+	// AuditStats.Dropped. Defaults to 1000.
+	Buffer int
+
+	// Timeout is the deadline for writing a record to each sink. Defaults to 5 seconds.
+	Timeout time.Duration
+}
+
+// withDefaults returns a copy of cfg with zero values replaced by defaults.
+func (cfg AuditConfig) withDefaults() AuditConfig {
+	if cfg.Buffer == 0 {
+		cfg.Buffer = 1000
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	return cfg
+}
+
+// problems returns a description of every invalid field in cfg, prefixed with name.
+func (cfg AuditConfig) problems(name string) []string {
+	var problems []string
+	if len(cfg.TokenKey) < minTokenKeyLen {
+		problems = append(problems, fmt.Sprintf("%s.TokenKey must be at least %d bytes, got %d",
+			name, minTokenKeyLen, len(cfg.TokenKey)))
+	}
+	if cfg.Buffer < 0 {
+		problems = append(problems, fmt.Sprintf("%s.Buffer must not be negative, got %d", name, cfg.Buffer))
+	}
+	if cfg.Timeout < 0 {
+		problems = append(problems, fmt.Sprintf("%s.Timeout must not be negative, got %s", name, cfg.Timeout))
+	}
+	return problems
+}
+
+// AuditStats reports the activity of an AuditLog.
+type AuditStats struct {
+	// Pending is the number of records currently waiting to be written to the sinks
+	Pending int
+
+	// Dropped is the total number of records not written because the buffer was full
+	// or the AuditLog was closed
+	Dropped uint64
+
+	// Failed is the total number of records a sink failed to write
+	Failed uint64
+}
+
+// TokenUsage counts the requests that used a bypass token.
+type TokenUsage struct {
+	// TokenID identifies the token (see AuditLog.TokenID)
+	TokenID string
+
+	// Count is the number of requests that used the token
+	Count uint64
+
+	// LastUsed is when the token was last used
+	LastUsed time.Time
+}
+
+// Reasons logged for dropped audit records.
+var (
+	errAuditBufferFull = errors.New("audit buffer is full")
+	errAuditClosed     = errors.New("audit log is closed")
+)
+
+// auditEntry is a record waiting to be written to the sinks, with the logger of the
+// configuration it was recorded under.
+type auditEntry struct {
+	record AuditRecord
+	log    *eventLogger
+}
+
+// AuditLog records every request that skips rate limiting because of a bypass token or
+// a whitelisted IP to its sinks, and counts the uses of each bypass token. Set it as
+// RateLimiterConfig.Audit. Create it with NewAuditLog.
+//
+// Records are written to the sinks by a background goroutine, so a slow sink never
+// holds up requests; records that don't fit in the buffer are dropped.
+type AuditLog struct {
+	sinks []AuditSink
+	cfg   AuditConfig
+
+	mu    sync.Mutex
+	usage map[string]*TokenUsage
+
+	// queueMu guards sends to queue against Close closing it
+	queueMu sync.RWMutex
+	queue   chan auditEntry
+	closed  bool
+	done    chan struct{}
+
+	dropped atomic.Uint64
+	failed  atomic.Uint64
+}
+
+// NewAuditLog validates cfg, creates an AuditLog writing to sinks and starts the
+// goroutine writing to them. Call Close to stop it.
+// It returns a *ValidationError if cfg is invalid.
+func NewAuditLog(cfg AuditConfig, sinks ...AuditSink) (*AuditLog, error) {
+	if err := newValidationError(cfg.problems("AuditConfig")); err != nil {
+		return nil, err
+	}
+	cfg = cfg.withDefaults()
+
+	a := &AuditLog{
+		sinks: sinks,
+		cfg:   cfg,
+		usage: make(map[string]*TokenUsage),
+		queue: make(chan auditEntry, cfg.Buffer),
+		done:  make(chan struct{}),
+	}
+	go a.run()
+	return a, nil
+}
+
+// TokenID returns the identifier of token in audit records, keyed with
+// AuditConfig.TokenKey (see BypassTokenID).
+func (a *AuditLog) TokenID(token string) string {
+	return BypassTokenID(a.cfg.TokenKey, token)
+}
+
+// Record counts the use of record's token, if it has one, and writes record to every
+// sink, waiting for them. It returns the errors of the sinks that failed, joined.
+// The rate limiter doesn't wait: it queues its records for the background goroutine.
+func (a *AuditLog) Record(ctx context.Context, record AuditRecord) error {
+	a.count(record)
+	return a.write(ctx, record)
+}
+
+// enqueue counts the use of record's token, if it has one, and queues record to be
+// written to the sinks, logging their failures to log. It returns why record was
+// dropped if the buffer is full or the AuditLog is closed.
+func (a *AuditLog) enqueue(record AuditRecord, log *eventLogger) error {
+	a.count(record)
+
+	a.queueMu.RLock()
+	defer a.queueMu.RUnlock()
+
+	if a.closed {
+		a.dropped.Add(1)
+		return errAuditClosed
+	}
+	select {
+	case a.queue <- auditEntry{record: record, log: log}:
+		return nil
+	default:
+		a.dropped.Add(1)
+		return errAuditBufferFull
+	}
+}
+
+// count counts the use of record's token, if it has one.
+func (a *AuditLog) count(record AuditRecord) {
+	if record.TokenID == "" {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	usage := a.usage[record.TokenID]
+	if usage == nil {
+		usage = &TokenUsage{TokenID: record.TokenID}
+		a.usage[record.TokenID] = usage
+	}
+	usage.Count++
+	usage.LastUsed = record.Time
+}
+
+// write writes record to every sink, each with its own AuditConfig.Timeout.
+func (a *AuditLog) write(ctx context.Context, record AuditRecord) error {
+	var errs []error
+	for _, sink := range a.sinks {
+		ctx, cancel := storageContext(ctx, a.cfg.Timeout)
+		err := sink.Audit(ctx, record)
+		cancel()
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (a *AuditLog) run() {
+	defer close(a.done)
+
+	for entry := range a.queue {
+		ctx := context.Background()
+		if err := a.write(ctx, entry.record); err != nil {
+			a.failed.Add(1)
+			entry.log.log(ctx, LogAuditError, "Rate limit bypass could not be audited",
+				slog.String("ip", entry.record.IP), slog.String("token_id", entry.record.TokenID),
+				slog.Any("error", err))
+		}
+	}
+}
+
+// Stats returns the current counters of the AuditLog.
+func (a *AuditLog) Stats() AuditStats {
+	return AuditStats{
+		Pending: len(a.queue),
+		Dropped: a.dropped.Load(),
+		Failed:  a.failed.Load(),
+	}
+}
+
+// Close stops accepting records, waits for the queued ones to be written and stops
+// the background goroutine. Close the sinks after it. Bypasses seen after Close are
+// dropped.
+func (a *AuditLog) Close() error {
+	a.queueMu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.queue)
+	}
+	a.queueMu.Unlock()
+
+	<-a.done
+	return nil
+}
+
+// Usage returns the use counts of the bypass tokens used since the AuditLog was
+// created, sorted by TokenID. Counts are kept by this instance only; use a
+// RedisAuditSink for counts across instances.
+func (a *AuditLog) Usage() []TokenUsage {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	usage := make([]TokenUsage, 0, len(a.usage))
+	for _, u := range a.usage {
+		usage = append(usage, *u)
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].TokenID < usage[j].TokenID })
+	return usage
+}
+
+// auditBypass queues the request c, which skipped rate limiting for reason, to be
+// recorded by cfg.Audit if it is set. Failures are logged and don't affect the request.
+func auditBypass(c *fiber.Ctx, cfg RateLimiterConfig, reason DecisionReason) {
+	if cfg.Audit == nil {
+		return
+	}
+
+	// The record outlives the request, so it must not share memory Fiber reuses
+	route, _ := matchRoute(c, cfg)
+	record := AuditRecord{
+		Time:   clockOrSystem(cfg.Clock).Now(),
+		Reason: reason,
+		IP:     strings.Clone(c.IP()),
+		Method: strings.Clone(c.Method()),
+		Route:  route,
+		Path:   strings.Clone(c.Path()),
+	}
+	if reason == ReasonBypassToken {
+		record.TokenID = cfg.Audit.TokenID(c.Get("X-RateLimit-Bypass"))
+	}
+
+	if err := cfg.Audit.enqueue(record, cfg.log()); err != nil {
+		cfg.log().log(c.UserContext(), LogAuditError, "Rate limit bypass could not be audited",
+			slog.String("ip", record.IP), slog.String("token_id", record.TokenID), slog.Any("error", err))
+	}
+}
+
+// FileAuditSink appends audit records to a file as JSON lines.
+type FileAuditSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileAuditSink opens the file at path for appending, creating it if needed with
+// permissions 0600, and returns a sink writing to it. Close the sink to close the file.
+func NewFileAuditSink(path string) (*FileAuditSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileAuditSink{file: file}, nil
+}
+
+// Audit implements AuditSink.
+func (s *FileAuditSink) Audit(_ context.Context, record AuditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.file.Write(line)
+	return err
+}
+
+// Close closes the file.
+func (s *FileAuditSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.file.Close()
+}
+
+// SlogAuditSink writes audit records to a slog.Logger.
+type SlogAuditSink struct {
+	logger *slog.Logger
+	level  slog.Level
+}
+
+// NewSlogAuditSink creates a sink writing audit records to logger, or slog.Default()
+// if it is nil, at level.
+func NewSlogAuditSink(logger *slog.Logger, level slog.Level) *SlogAuditSink {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &SlogAuditSink{logger: logger, level: level}
+}
+
+// Audit implements AuditSink.
+func (s *SlogAuditSink) Audit(ctx context.Context, record AuditRecord) error {
+	s.logger.LogAttrs(ctx, s.level, "Rate limit bypassed",
+		slog.Time("time", record.Time),
+		slog.String("reason", string(record.Reason)),
+		slog.String("token_id", record.TokenID),
+		slog.String("ip", record.IP),
+		slog.String("method", record.Method),
+		slog.String("route", record.Route),
+		slog.String("path", record.Path),
+	)
+	return nil
+}
+
+// RedisAuditConfig defines where a RedisAuditSink writes.
+// Zero values are replaced with the defaults noted on each field.
+type RedisAuditConfig struct {
+	// Stream is the key of the Redis stream records are added to.
+	// Defaults to "ratelimit:audit".
+	Stream string
+
+	// MaxLen is the approximate number of records the stream keeps; older records
+	// are trimmed. Defaults to 100000.
+	MaxLen int64
+
+	// UsageKey is the key of the hash counting the uses of each bypass token, by
+	// token ID. Defaults to Stream + ":usage".
+	UsageKey string
+}
+
+// withDefaults returns a copy of cfg with zero values replaced by defaults.
+func (cfg RedisAuditConfig) withDefaults() RedisAuditConfig {
+	if cfg.Stream == "" {
+		cfg.Stream = "ratelimit:audit"
+	}
+	if cfg.MaxLen <= 0 {
+		cfg.MaxLen = 100000
+	}
+	if cfg.UsageKey == "" {
+		cfg.UsageKey = cfg.Stream + ":usage"
+	}
+	return cfg
+}
+
+// RedisAuditSink adds audit records to a Redis stream and counts the uses of each
+// bypass token in a hash, shared by every instance writing to it.
+type RedisAuditSink struct {
+	client redis.UniversalClient
+	cfg    RedisAuditConfig
+}
+
+// NewRedisAuditSink creates a sink writing to client as configured by cfg.
+func NewRedisAuditSink(client redis.UniversalClient, cfg RedisAuditConfig) *RedisAuditSink {
+	return &RedisAuditSink{client: client, cfg: cfg.withDefaults()}
+}
+
+// Audit implements AuditSink. The stream and the usage hash are separate keys, so they
+// are written in a pipeline rather than a transaction to support Redis Cluster.
+func (s *RedisAuditSink) Audit(ctx context.Context, record AuditRecord) error {
+	pipe := s.client.Pipeline()
+	pipe.XAdd(ctx, &redis.XAddArgs{
+		Stream: s.cfg.Stream,
+		MaxLen: s.cfg.MaxLen,
+		Approx: true,
+		Values: map[string]any{
+			"time":     record.Time.Format(time.RFC3339Nano),
+			"reason":   string(record.Reason),
+			"token_id": record.TokenID,
+			"ip":       record.IP,
+			"method":   record.Method,
+			"route":    record.Route,
+			"path":     record.Path,
+		},
+	})
+	if record.TokenID != "" {
+		pipe.HIncrBy(ctx, s.cfg.UsageKey, record.TokenID, 1)
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return WrapUnavailable("Audit", s.cfg.Stream, err)
+	}
+	return nil
+}
+
+// Usage returns the use counts of the bypass tokens, by token ID, across every instance
+// writing to the sink's usage hash.
+func (s *RedisAuditSink) Usage(ctx context.Context) (map[string]int64, error) {
+	counts, err := s.client.HGetAll(ctx, s.cfg.UsageKey).Result()
+	if err != nil {
+		return nil, WrapUnavailable("Usage", s.cfg.UsageKey, err)
+	}
+
+	usage := make(map[string]int64, len(counts))
+	for id, count := range counts {
+		n, err := strconv.ParseInt(count, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		usage[id] = n
+	}
+	return usage, nil
+}
@@ -0,0 +1,140 @@
+package rateLimiter_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	rl "github.com/Popoola-Opeyemi/rateLimiter"
+	"github.com/gofiber/fiber/v2"
+)
+
+var testTokenKey = []byte("0123456789abcdef")
+
+// recordingSink keeps the records written to it. If block is set, Audit signals
+// started, which must be buffered, and waits for block to be closed before recording.
+type recordingSink struct {
+	started chan struct{}
+	block   chan struct{}
+
+	mu      sync.Mutex
+	records []rl.AuditRecord
+}
+
+func (s *recordingSink) Audit(_ context.Context, record rl.AuditRecord) error {
+	if s.block != nil {
+		s.started <- struct{}{}
+		<-s.block
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records = append(s.records, record)
+	return nil
+}
+
+// bypass requests path from app with the bypass token and returns the response status.
+func bypass(t *testing.T, app *fiber.App, path string) int {
+	t.Helper()
+
+	req := httptest.NewRequest("GET", path, nil)
+	req.Header.Set("X-RateLimit-Bypass", "bypass-token")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode
+}
+
+func auditConfig(audit *rl.AuditLog) rl.RateLimiterConfig {
+	cfg := baseConfig()
+	cfg.GlobalSecurity.BypassTokens = []string{"bypass-token"}
+	cfg.RoutePolicy = map[string]rl.Policy{
+		"/admin": {MaxRequests: 1, BurstCapacity: 1, TokensPerSecond: 0.01},
+	}
+	cfg.Audit = audit
+	return cfg
+}
+
+func TestAuditRecordsMatchedRoute(t *testing.T) {
+	sink := &recordingSink{}
+	audit, err := rl.NewAuditLog(rl.AuditConfig{TokenKey: testTokenKey}, sink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := newUseApp(t, auditConfig(audit))
+
+	bypass(t, app, "/admin")
+	bypass(t, app, "/users")
+	audit.Close()
+
+	if len(sink.records) != 2 {
+		t.Fatalf("got %d records, want 2", len(sink.records))
+	}
+	for i, want := range []string{"/admin", "/"} {
+		if record := sink.records[i]; record.Route != want || record.TokenID != audit.TokenID("bypass-token") {
+			t.Errorf("record %d: route %q, token ID %q; want %q and %q",
+				i, record.Route, record.TokenID, want, audit.TokenID("bypass-token"))
+		}
+	}
+}
+
+func TestSlowAuditSinkDoesNotHoldUpRequests(t *testing.T) {
+	sink := &recordingSink{started: make(chan struct{}, 3), block: make(chan struct{})}
+	audit, err := rl.NewAuditLog(rl.AuditConfig{TokenKey: testTokenKey, Buffer: 1}, sink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := newUseApp(t, auditConfig(audit))
+
+	// The first record is being written, the second waits in the buffer and the third
+	// doesn't fit
+	if status := bypass(t, app, "/users"); status != fiber.StatusOK {
+		t.Fatalf("first request: status %d, want 200", status)
+	}
+	select {
+	case <-sink.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("sink not called")
+	}
+	for i := 2; i <= 3; i++ {
+		if status := bypass(t, app, "/users"); status != fiber.StatusOK {
+			t.Fatalf("request %d: status %d, want 200", i, status)
+		}
+	}
+	if stats := audit.Stats(); stats.Pending != 1 || stats.Dropped != 1 {
+		t.Fatalf("stats while the sink is blocked: %+v, want 1 pending and 1 dropped", stats)
+	}
+
+	close(sink.block)
+	audit.Close()
+	if len(sink.records) != 2 {
+		t.Fatalf("got %d records after Close, want 2", len(sink.records))
+	}
+	if usage := audit.Usage(); len(usage) != 1 || usage[0].Count != 3 {
+		t.Fatalf("usage: %+v, want 3 uses of one token", usage)
+	}
+}
+
+func TestBypassTokenIDDependsOnKey(t *testing.T) {
+	id := rl.BypassTokenID(testTokenKey, "bypass-token")
+	if len(id) != 16 {
+		t.Fatalf("token ID %q: want 16 hex digits", id)
+	}
+	if other := rl.BypassTokenID([]byte("fedcba9876543210"), "bypass-token"); other == id {
+		t.Fatalf("token ID %q is the same under another key", id)
+	}
+}
+
+func TestNewAuditLogRequiresTokenKey(t *testing.T) {
+	_, err := rl.NewAuditLog(rl.AuditConfig{TokenKey: []byte("short")})
+
+	var invalid *rl.ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("NewAuditLog with a 5 byte key: got %v, want a ValidationError", err)
+	}
+}
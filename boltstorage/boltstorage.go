@@ -0,0 +1,304 @@
+// Package boltstorage provides a rateLimiter.Storage kept in an embedded bbolt database
+// file, so buckets survive restarts on single-node deployments without Redis:
+//
+//	storage, err := boltstorage.New("/var/lib/myapp/ratelimit.db", boltstorage.Config{})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer storage.Close()
+//
+//	limiter, err := rateLimiter.NewLimiter(rateLimiter.RateLimiterConfig{
+//		Storage: storage,
+//		// ...
+//	})
+//
+// Each write is its own synced transaction. Config.Batch combines concurrent writes to
+// cut the number of fsyncs, but holds each one for up to the database's MaxBatchDelay,
+// which adds that much latency to rate limited requests.
+//
+// It is a separate package so that applications not using it don't depend on bbolt.
+package boltstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	rateLimiter "github.com/Popoola-Opeyemi/rateLimiter"
+	bolt "go.etcd.io/bbolt"
+)
+
+var _ rateLimiter.AtomicStorage = (*Storage)(nil)
+
+// boltBucketName is the bbolt bucket holding the rate limit buckets.
+var boltBucketName = []byte("ratelimit_buckets")
+
+// Config defines how a Storage uses its database file.
+// Zero values are replaced with the defaults noted on each field.
+type Config struct {
+	// SweepInterval is how often expired buckets are deleted from the file.
+	// Defaults to 1 minute; a negative value disables sweeping.
+	SweepInterval time.Duration
+
+	// OpenTimeout is how long to wait for the file lock when another process has the
+	// database open. Defaults to 1 second.
+	OpenTimeout time.Duration
+
+	// NoSync skips the fsync after each write. This is much faster, but writes made
+	// shortly before a crash or power loss may be lost. The file itself stays consistent.
+	NoSync bool
+
+	// Batch combines concurrent writes into a single transaction and fsync, which raises
+	// throughput under load but delays each write by up to the database's MaxBatchDelay
+	// (10 milliseconds by default), adding that latency to every rate limited request.
+	// By default each write is its own transaction.
+	Batch bool
+
+	// Clock is the source of the current time for bucket updates and expiry. Defaults to the system clock.
+	Clock rateLimiter.Clock
+}
+
+// withDefaults returns a copy of cfg with zero values replaced by defaults.
+func (cfg Config) withDefaults() Config {
+	if cfg.SweepInterval == 0 {
+		cfg.SweepInterval = time.Minute
+	}
+	if cfg.OpenTimeout == 0 {
+		cfg.OpenTimeout = time.Second
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = rateLimiter.SystemClock()
+	}
+	return cfg
+}
+
+// Storage implements rateLimiter.Storage on top of an embedded bbolt database file.
+// Bucket state survives process restarts, which makes it suitable for single-node
+// deployments without Redis. Every write is a transaction that is synced to disk before
+// it returns (unless NoSync is set), so the file is never left half-written by a crash.
+//
+// It implements rateLimiter.AtomicStorage. Call Close to stop the sweeper and release the file.
+type Storage struct {
+	db    *bolt.DB
+	clock rateLimiter.Clock
+	batch bool
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// New opens (creating if needed) the database file at path and starts
+// sweeping expired buckets in the background.
+func New(path string, cfg Config) (*Storage, error) {
+	cfg = cfg.withDefaults()
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: cfg.OpenTimeout, NoSync: cfg.NoSync})
+	if err != nil {
+		return nil, fmt.Errorf("open rate limit database %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucketName)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("open rate limit database %s: %w", path, err)
+	}
+
+	bs := &Storage{
+		db:    db,
+		clock: cfg.Clock,
+		batch: cfg.Batch,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	if cfg.SweepInterval > 0 {
+		go bs.sweeper(cfg.SweepInterval)
+	} else {
+		close(bs.done)
+	}
+	return bs, nil
+}
+
+// StorageName implements rateLimiter.NamedStorage.
+func (bs *Storage) StorageName() string {
+	return "bolt"
+}
+
+// Close stops the sweeper and closes the database file.
+func (bs *Storage) Close() error {
+	bs.once.Do(func() {
+		close(bs.stop)
+	})
+	<-bs.done
+	return bs.db.Close()
+}
+
+// GetBucket retrieves the current state of a rate limit bucket from the database.
+// If the bucket doesn't exist or has expired, it returns default values.
+func (bs *Storage) GetBucket(ctx context.Context, key string) (float64, time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, time.Time{}, rateLimiter.WrapTimeout("GetBucket", key, err)
+	}
+
+	var (
+		tokens     float64
+		lastUpdate time.Time
+	)
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		var err error
+		tokens, lastUpdate, err = readBoltBucket(tx, key, bs.clock.Now())
+		return err
+	})
+	if err != nil {
+		return 0, time.Time{}, bs.wrapError("GetBucket", key, err)
+	}
+	return tokens, lastUpdate, nil
+}
+
+// UpdateBucket updates the state of a rate limit bucket in the database.
+func (bs *Storage) UpdateBucket(ctx context.Context, key string, tokens float64, expiry time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return rateLimiter.WrapTimeout("UpdateBucket", key, err)
+	}
+
+	err := bs.update(func(tx *bolt.Tx) error {
+		return writeBoltBucket(tx, key, tokens, bs.clock.Now(), expiry)
+	})
+	return bs.wrapError("UpdateBucket", key, err)
+}
+
+// update runs fn in a write transaction, batched with concurrent writes if Config.Batch
+// is set. A batched fn may be run more than once.
+func (bs *Storage) update(fn func(*bolt.Tx) error) error {
+	if bs.batch {
+		return bs.db.Batch(fn)
+	}
+	return bs.db.Update(fn)
+}
+
+// TakeToken refills the bucket at key and takes a token from it within a single
+// write transaction. A corrupt bucket is reset to full capacity.
+func (bs *Storage) TakeToken(ctx context.Context, key string, capacity int, rate float64,
+	expiry time.Duration) (float64, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, false, rateLimiter.WrapTimeout("TakeToken", key, err)
+	}
+
+	var (
+		tokens  float64
+		allowed bool
+	)
+	err := bs.update(func(tx *bolt.Tx) error {
+		now := bs.clock.Now()
+
+		stored, lastUpdate, err := readBoltBucket(tx, key, now)
+		if err != nil && !errors.Is(err, rateLimiter.ErrCorruptState) {
+			return err
+		}
+
+		tokens = float64(capacity)
+		if err == nil && !lastUpdate.IsZero() {
+			tokens = rateLimiter.RefillTokens(stored, lastUpdate, now, capacity, rate)
+		}
+
+		allowed = tokens >= 1
+		if allowed {
+			tokens--
+		}
+		return writeBoltBucket(tx, key, tokens, now, expiry)
+	})
+	if err != nil {
+		return 0, false, bs.wrapError("TakeToken", key, err)
+	}
+	return tokens, allowed, nil
+}
+
+// wrapError classifies a database error. Decoding errors are already wrapped with
+// rateLimiter.ErrCorruptState; anything else means the database can't be used.
+func (bs *Storage) wrapError(op, key string, err error) error {
+	if err == nil || errors.Is(err, rateLimiter.ErrCorruptState) {
+		return err
+	}
+	return rateLimiter.WrapUnavailable(op, key, err)
+}
+
+// sweeper deletes expired buckets every interval until Close is called.
+func (bs *Storage) sweeper(interval time.Duration) {
+	defer close(bs.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-bs.stop:
+			return
+		case <-ticker.C:
+			bs.sweep(bs.clock.Now())
+		}
+	}
+}
+
+// sweep deletes every bucket that has expired at now. Keys are collected in a read
+// transaction and deleted in small write transactions, so requests aren't held up
+// behind one long write.
+func (bs *Storage) sweep(now time.Time) {
+	const batchSize = 1000
+
+	var expired [][]byte
+	bs.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucketName).ForEach(func(k, v []byte) error {
+			if _, _, expiresAt, err := rateLimiter.DecodeBucket(string(k), v); err != nil || now.After(expiresAt) {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+			return nil
+		})
+	})
+
+	for start := 0; start < len(expired); start += batchSize {
+		batch := expired[start:min(start+batchSize, len(expired))]
+		bs.db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket(boltBucketName)
+			for _, k := range batch {
+				// The bucket may have been refreshed since it was collected
+				if v := b.Get(k); v != nil {
+					if _, _, expiresAt, err := rateLimiter.DecodeBucket(string(k), v); err == nil && !now.After(expiresAt) {
+						continue
+					}
+				}
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+}
+
+// readBoltBucket decodes the bucket at key. A missing or expired bucket is
+// returned as zero tokens with a zero lastUpdate.
+func readBoltBucket(tx *bolt.Tx, key string, now time.Time) (float64, time.Time, error) {
+	v := tx.Bucket(boltBucketName).Get([]byte(key))
+	if v == nil {
+		return 0, time.Time{}, nil
+	}
+
+	tokens, lastUpdate, expiresAt, err := rateLimiter.DecodeBucket(key, v)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	if now.After(expiresAt) {
+		return 0, time.Time{}, nil
+	}
+	return tokens, lastUpdate, nil
+}
+
+// writeBoltBucket encodes and stores the bucket at key.
+func writeBoltBucket(tx *bolt.Tx, key string, tokens float64, now time.Time, expiry time.Duration) error {
+	return tx.Bucket(boltBucketName).Put([]byte(key), rateLimiter.EncodeBucket(tokens, now, now.Add(expiry)))
+}
@@ -0,0 +1,67 @@
+package boltstorage_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	rateLimiter "github.com/Popoola-Opeyemi/rateLimiter"
+	"github.com/Popoola-Opeyemi/rateLimiter/boltstorage"
+	"github.com/Popoola-Opeyemi/rateLimiter/clocktest"
+	"github.com/Popoola-Opeyemi/rateLimiter/storagetest"
+)
+
+func TestStorage(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		cfg  boltstorage.Config
+	}{
+		{"Unbatched", boltstorage.Config{}},
+		{"Batched", boltstorage.Config{Batch: true}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var clock *clocktest.Clock
+			storagetest.RunWithOptions(t, func(tb testing.TB) rateLimiter.Storage {
+				clock = clocktest.New(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+				cfg := tc.cfg
+				cfg.NoSync, cfg.SweepInterval, cfg.Clock = true, -1, clock
+				s, err := boltstorage.New(filepath.Join(tb.TempDir(), "ratelimit.db"), cfg)
+				if err != nil {
+					tb.Fatal(err)
+				}
+				tb.Cleanup(func() { s.Close() })
+				return s
+			}, storagetest.Options{
+				Now:     func() time.Time { return clock.Now() },
+				Advance: func(d time.Duration) { clock.Advance(d) },
+			})
+		})
+	}
+}
+
+func TestBucketsSurviveReopening(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ratelimit.db")
+	ctx := context.Background()
+
+	s, err := boltstorage.New(path, boltstorage.Config{SweepInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateBucket(ctx, "rl:user1:/", 7, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = boltstorage.New(path, boltstorage.Config{SweepInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if tokens, _, err := s.GetBucket(ctx, "rl:user1:/"); err != nil || tokens != 7 {
+		t.Fatalf("GetBucket after reopening = %v, %v; want 7 tokens", tokens, err)
+	}
+}
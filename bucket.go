@@ -2,88 +2,349 @@ package rateLimiter
 
 import (
 	"context"
+	"errors"
+	"log/slog"
 	"math"
+	"strings"
 	"time"
+
+	"github.com/gofiber/fiber/v2"
 )
 
+// bucketStore bundles the storage backends and settings used by checkTokenBucket.
+type bucketStore struct {
+	primary  Storage
+	fallback Storage
+
+	// timeout is the deadline for each storage operation, 0 for none
+	timeout time.Duration
+
+	// reconciler tracks buckets whose primary write failed. May be nil.
+	reconciler *Reconciler
+
+	// clock is the source of the current time, nil for the system clock
+	clock Clock
+
+	// metrics receives storage measurements if it is a StorageRecorder. May be nil.
+	metrics MetricsRecorder
+
+	// tracer traces storage calls. May be nil.
+	tracer Tracer
+
+	// log receives storage failures and fallbacks. May be nil.
+	log *eventLogger
+
+	// events receives EventStorageFallback. May be nil.
+	events *EventBus
+
+	// ip and route are the client IP and route of the request being checked, set by
+	// forRequest for the events published
+	ip, route string
+}
+
+// forRequest returns a copy of store that publishes its events with the client IP and
+// route of c. Both are copied, since subscriptions receive events after the request.
+func (store bucketStore) forRequest(c *fiber.Ctx, cfg RateLimiterConfig) bucketStore {
+	if store.events != nil {
+		store.ip = strings.Clone(c.IP())
+		store.route, _ = matchRoute(c, cfg)
+	}
+	return store
+}
+
+// guardRedis makes call, a call to Redis outside of the Storage interface such as the
+// IP block checks, through the circuit breaker of the primary storage if it wraps a
+// RedisStorage, so the call fails fast with ErrCircuitOpen while Redis is down.
+func (store bucketStore) guardRedis(call func() error) error {
+	if cb, ok := circuitBreakerOf(store.primary); ok {
+		if _, ok := cb.storage.(*RedisStorage); ok {
+			return cb.do(call)
+		}
+	}
+	return call()
+}
+
+// now returns the current time according to the store's clock.
+func (store bucketStore) now() time.Time {
+	return clockOrSystem(store.clock).Now()
+}
+
+// begin starts a call to storage, returning the context to make it with and a function
+// to call with its error once it returns, which records its latency and ends its span.
+func (store bucketStore) begin(ctx context.Context, storage Storage,
+	op string) (context.Context, func(error)) {
+	ctx, endSpan := startStorageSpan(ctx, store.tracer, storage, op)
+	start := store.now()
+	return ctx, func(err error) {
+		if recorder, ok := store.metrics.(StorageRecorder); ok {
+			recorder.StorageCall(StorageName(storage), op, store.now().Sub(start), err)
+		}
+		endSpan(err)
+	}
+}
+
+// fallbackUsed reports that the bucket at key is checked on the fallback storage
+// because the primary storage failed with err.
+func (store bucketStore) fallbackUsed(ctx context.Context, key string, err error) {
+	store.log.log(ctx, LogFallback, "Rate limit storage failed, using fallback storage",
+		slog.String("key", key), slog.String("backend", StorageName(store.primary)), slog.Any("error", err))
+	if recorder, ok := store.metrics.(StorageRecorder); ok {
+		recorder.FallbackUsed()
+	}
+	if store.events != nil {
+		store.events.Publish(Event{Type: EventStorageFallback, Time: store.now(), IP: store.ip,
+			Route: store.route, Key: key, Backend: StorageName(store.primary), Err: err})
+	}
+}
+
+// bucketResult is the outcome of a token bucket check.
+type bucketResult struct {
+	// allowed is whether the request may go through
+	allowed bool
+
+	// tokens is the number of tokens left in the bucket after the check
+	tokens float64
+
+	// retryAfter is how long until the bucket holds a whole token again, zero if it does
+	retryAfter time.Duration
+
+	// resetAfter is how long until the bucket is full again
+	resetAfter time.Duration
+
+	// unknown is set when the request was decided without reading the bucket, so
+	// tokens, retryAfter and resetAfter are meaningless
+	unknown bool
+
+	// policy is the policy the bucket was checked against
+	policy Policy
+
+	// backend is the name of the storage the bucket was read from (see StorageName),
+	// empty if it wasn't read
+	backend string
+
+	// fallback is set when the primary storage failed, so the request was decided on the
+	// fallback storage or by the policy's FailureMode
+	fallback bool
+
+	// shadow is set for the result of a shadow policy, which is reported but never
+	// decides the request
+	shadow bool
+}
+
+// newBucketResult describes a bucket of policy left holding tokens after a check.
+func newBucketResult(allowed bool, tokens float64, policy Policy) bucketResult {
+	result := bucketResult{
+		allowed:    allowed,
+		tokens:     tokens,
+		resetAfter: refillDuration(float64(policy.BurstCapacity)-tokens, policy),
+		policy:     policy,
+	}
+	if tokens < 1 {
+		result.retryAfter = refillDuration(1-tokens, policy)
+	}
+	return result
+}
+
+// servedBy returns r as read from source, one of the storages of store.
+func (r bucketResult) servedBy(store bucketStore, source Storage) bucketResult {
+	r.backend = StorageName(source)
+	r.fallback = source != store.primary
+	return r
+}
+
+// retryAfterSeconds returns retryAfter in whole seconds, rounded up and at least 1,
+// as used by the Retry-After header.
+func (r bucketResult) retryAfterSeconds() int {
+	return max(1, int(math.Ceil(r.retryAfter.Seconds())))
+}
+
+// remaining returns the number of whole tokens left in the bucket.
+func (r bucketResult) remaining() int {
+	return max(0, int(math.Floor(r.tokens)))
+}
+
 // checkTokenBucket implements the token bucket algorithm for rate limiting.
 // It manages a bucket of tokens that are consumed by requests and refilled over time.
 //
 // The function takes a key (typically user ID or IP), a policy defining the rate limits,
-// and both primary and fallback storage backends. It returns:
-//   - bool: whether the request should be allowed
-//   - int: seconds to wait if the request is rejected
-//   - error: any error that occurred during the check
+// and the storage backends to use. It returns whether the request is allowed together
+// with the state of the bucket after the check, or any error that occurred during the check.
+//
+// If the primary storage fails, the fallback storage is used instead. A bucket that
+// is corrupt in the storage it's read from (ErrCorruptState) is reset to full capacity.
 //
 // The token bucket algorithm works as follows:
 //  1. Each request consumes one token
 //  2. Tokens are refilled at a constant rate (TokensPerSecond)
 //  3. The bucket has a maximum capacity (BurstCapacity)
 //  4. If the bucket is empty, requests are rejected
-func checkTokenBucket(ctx context.Context, primaryStorage, fallbackStorage Storage,
-	key string, policy Policy) (bool, int, error) {
+func checkTokenBucket(ctx context.Context, store bucketStore, key string, policy Policy) (bucketResult, error) {
+	// Time to refill the entire bucket (used as TTL)
+	ttl := bucketTTL(policy)
+
+	// Storages that can refill and take a token atomically do the whole check in one step
+	if atomicStorage, ok := store.primary.(AtomicStorage); ok {
+		return takeToken(ctx, atomicStorage, store, key, policy, ttl)
+	}
 
 	var (
 		tokens     float64
 		lastUpdate time.Time
 		err        error
-		now        = time.Now()
+		now        = store.now()
 	)
 
-	// Try to get bucket from primary storage
-	tokens, lastUpdate, err = primaryStorage.GetBucket(ctx, key)
-	if err != nil {
-		tokens, lastUpdate, err = fallbackStorage.GetBucket(ctx, key)
-		if err != nil {
-			return false, 0, err
+	// Try to get bucket from primary storage. A corrupt bucket means the storage is
+	// reachable, so it is reset rather than read from the fallback storage; the fresh
+	// state written below overwrites it
+	source := store.primary
+	tokens, lastUpdate, err = getBucket(ctx, store, store.primary, key)
+	if err != nil && !errors.Is(err, ErrCorruptState) {
+		if store.fallback != store.primary {
+			store.fallbackUsed(ctx, key, err)
 		}
+		source = store.fallback
+		tokens, lastUpdate, err = getBucket(ctx, store, store.fallback, key)
+	}
+	if errors.Is(err, ErrCorruptState) {
+		tokens, lastUpdate = 0, time.Time{}
+	} else if err != nil {
+		return bucketResult{}, err
 	}
-
-	// Time to refill the entire bucket (used as TTL)
-	ttl := time.Duration(float64(policy.BurstCapacity)/policy.TokensPerSecond) * time.Second
 
 	// If bucket is uninitialized
 	if lastUpdate.IsZero() {
 		tokens = float64(policy.BurstCapacity)
 		lastUpdate = now
-		updateBothStorages(ctx, key, tokens, ttl, primaryStorage, fallbackStorage)
+		updateBothStorages(ctx, store, key, tokens, ttl, policy)
 	} else {
 		// Calculate elapsed time and refill tokens
-		elapsed := now.Sub(lastUpdate).Seconds()
-		refilled := elapsed * policy.TokensPerSecond
-		tokens = min(float64(policy.BurstCapacity), tokens+refilled)
+		tokens = RefillTokens(tokens, lastUpdate, now, policy.BurstCapacity, policy.TokensPerSecond)
 	}
 
 	// Not enough tokens to allow request
 	if tokens < 1 {
-		tokensNeeded := 1 - tokens
-		secondsToWait := int(math.Ceil(tokensNeeded / policy.TokensPerSecond))
+		updateBothStorages(ctx, store, key, tokens, ttl, policy)
+		return newBucketResult(false, tokens, policy).servedBy(store, source), nil
+	}
+
+	// Consume one token
+	tokens--
+
+	updateBothStorages(ctx, store, key, tokens, ttl, policy)
+	return newBucketResult(true, tokens, policy).servedBy(store, source), nil
+}
+
+// takeToken runs the token bucket check in a single step on a primary storage that
+// implements AtomicStorage, and mirrors the result to the fallback storage.
+// If the primary fails, the check is repeated against the fallback storage alone.
+func takeToken(ctx context.Context, primary AtomicStorage, store bucketStore, key string,
+	policy Policy, ttl time.Duration) (bucketResult, error) {
+
+	opCtx, cancel := storageContext(ctx, store.timeout)
+	opCtx, done := store.begin(opCtx, primary, "TakeToken")
+	tokens, allowed, err := primary.TakeToken(opCtx, key, policy.BurstCapacity, policy.TokensPerSecond, ttl)
+	done(err)
+	cancel()
+
+	if err != nil {
+		if store.primary == store.fallback {
+			return bucketResult{}, err
+		}
+		trackFailedWrite(store, key, ttl, policy, err)
+		store.fallbackUsed(ctx, key, err)
+		fallback := store
+		fallback.primary = store.fallback
+		fallback.reconciler = nil
+		result, err := checkTokenBucket(ctx, fallback, key, policy)
+		result.fallback = true
+		return result, err
+	}
 
-		// Ensure at least 1 second wait time
-		if secondsToWait < 1 {
-			secondsToWait = 1
+	if store.fallback != store.primary {
+		if err := updateBucket(ctx, store, store.fallback, key, tokens, ttl); err != nil {
+			store.writeFailed(ctx, store.fallback, key, err)
 		}
+	}
 
-		updateBothStorages(ctx, key, tokens, ttl, primaryStorage, fallbackStorage)
-		return false, secondsToWait, nil
+	return newBucketResult(allowed, tokens, policy).servedBy(store, store.primary), nil
+}
+
+// bucketTTL returns the time it takes to refill an empty bucket, which is how long
+// a bucket has to be kept before it is indistinguishable from a new one.
+func bucketTTL(policy Policy) time.Duration {
+	return refillDuration(float64(policy.BurstCapacity), policy)
+}
+
+// refillDuration returns the time it takes to refill tokens at the policy's rate.
+func refillDuration(tokens float64, policy Policy) time.Duration {
+	if tokens <= 0 {
+		return 0
 	}
+	return time.Duration(tokens / policy.TokensPerSecond * float64(time.Second))
+}
 
-	// Consume one token
-	tokens--
+// getBucket reads a bucket from one of the store's storages with its own operation deadline.
+func getBucket(ctx context.Context, store bucketStore, storage Storage,
+	key string) (float64, time.Time, error) {
+	ctx, cancel := storageContext(ctx, store.timeout)
+	defer cancel()
+
+	ctx, done := store.begin(ctx, storage, "GetBucket")
+	tokens, lastUpdate, err := storage.GetBucket(ctx, key)
+	done(err)
+	return tokens, lastUpdate, err
+}
+
+// updateBucket writes a bucket to one of the store's storages with its own operation deadline.
+func updateBucket(ctx context.Context, store bucketStore, storage Storage, key string,
+	tokens float64, ttl time.Duration) error {
+	ctx, cancel := storageContext(ctx, store.timeout)
+	defer cancel()
+
+	ctx, done := store.begin(ctx, storage, "UpdateBucket")
+	err := storage.UpdateBucket(ctx, key, tokens, ttl)
+	done(err)
+	return err
+}
 
-	updateBothStorages(ctx, key, tokens, ttl, primaryStorage, fallbackStorage)
-	return true, 0, nil
+// RefillTokens returns the token count of a bucket that held tokens at lastUpdate,
+// refilled at rate tokens per second until now and capped at capacity.
+func RefillTokens(tokens float64, lastUpdate, now time.Time, capacity int, rate float64) float64 {
+	elapsed := now.Sub(lastUpdate).Seconds()
+	refilled := elapsed * rate
+	return min(float64(capacity), tokens+refilled)
 }
 
 // updateBothStorages updates the bucket state in both primary and fallback storage.
 // This ensures consistency between storage backends and provides redundancy.
-// If an error occurs during update, it is currently logged but not returned.
-func updateBothStorages(ctx context.Context, key string, tokens float64,
-	ttl time.Duration, primary, fallback Storage) {
-	if err := primary.UpdateBucket(ctx, key, tokens, ttl); err != nil {
-		// TODO: Add logging
-	}
-	if err := fallback.UpdateBucket(ctx, key, tokens, ttl); err != nil {
-		// TODO: Add logging
+// If the primary write fails because the backend is down, the bucket is handed to the
+// reconciler so the fallback state is replayed once the primary recovers.
+func updateBothStorages(ctx context.Context, store bucketStore, key string, tokens float64,
+	ttl time.Duration, policy Policy) {
+	if err := updateBucket(ctx, store, store.primary, key, tokens, ttl); err != nil {
+		store.writeFailed(ctx, store.primary, key, err)
+		trackFailedWrite(store, key, ttl, policy, err)
+	}
+	if store.fallback == store.primary {
+		return
+	}
+	if err := updateBucket(ctx, store, store.fallback, key, tokens, ttl); err != nil {
+		store.writeFailed(ctx, store.fallback, key, err)
+	}
+}
+
+// writeFailed logs a failed write of the bucket at key to storage.
+func (store bucketStore) writeFailed(ctx context.Context, storage Storage, key string, err error) {
+	store.log.log(ctx, LogStorageError, "Rate limit bucket write failed",
+		slog.String("key", key), slog.String("backend", StorageName(storage)), slog.Any("error", err))
+}
+
+// trackFailedWrite hands a bucket whose primary write failed with err to the reconciler,
+// if there is one and the primary is unavailable rather than rejecting the write.
+func trackFailedWrite(store bucketStore, key string, ttl time.Duration, policy Policy, err error) {
+	if store.reconciler != nil && store.primary != store.fallback &&
+		(errors.Is(err, ErrStorageUnavailable) || errors.Is(err, ErrTimeout)) {
+		store.reconciler.Track(key, ttl, policy)
 	}
 }
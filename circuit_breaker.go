@@ -0,0 +1,353 @@
+package rateLimiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by CircuitBreakerStorage while the circuit is open,
+// without calling the wrapped storage. It wraps ErrStorageUnavailable.
+var ErrCircuitOpen = fmt.Errorf("%w: circuit open", ErrStorageUnavailable)
+
+// CircuitState is the state of a CircuitBreakerStorage.
+type CircuitState int32
+
+const (
+	// CircuitClosed passes every call through to the wrapped storage.
+	CircuitClosed CircuitState = iota
+
+	// CircuitOpen fails every call immediately with ErrCircuitOpen.
+	CircuitOpen
+
+	// CircuitHalfOpen lets a limited number of probe calls through to test
+	// whether the wrapped storage has recovered.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("CircuitState(%d)", int32(s))
+	}
+}
+
+// CircuitBreakerConfig defines when a CircuitBreakerStorage opens and how it recovers.
+// Zero values are replaced with the defaults noted on each field.
+type CircuitBreakerConfig struct {
+	// FailureRatio is the share of failed calls within Window that opens the circuit.
+	// A call fails when it returns ErrStorageUnavailable or ErrTimeout; other errors,
+	// such as ErrCorruptState, come from a reachable storage and count as successes.
+	// It must be in (0, 1]; 0 means the default, 0.5. To open on any failure once
+	// MinRequests calls have been made, use a ratio below 1/MinRequests, such as 0.001.
+	FailureRatio float64
+
+	// MinRequests is the number of calls required within Window before FailureRatio
+	// is evaluated, so a single error on a quiet instance doesn't open the circuit.
+	// Defaults to 20.
+	MinRequests int
+
+	// Window is the length of the window in which calls are counted. Defaults to 10 seconds.
+	Window time.Duration
+
+	// SlowCallThreshold is the latency above which a successful call is counted as a failure.
+	// Defaults to 100 milliseconds.
+	SlowCallThreshold time.Duration
+
+	// OpenTimeout is how long the circuit stays open before probing the storage again.
+	// Defaults to 5 seconds.
+	OpenTimeout time.Duration
+
+	// HalfOpenProbes is the number of consecutive successful probes needed to close
+	// the circuit again. It is also the maximum number of probes in flight. Defaults to 3.
+	HalfOpenProbes int
+
+	// OnStateChange, if not nil, is called whenever the circuit changes state.
+	// It is called with the breaker's lock released, but must not block.
+	OnStateChange func(from, to CircuitState)
+
+	// Clock is the source of the current time for the window, the open timeout and
+	// call latency. Defaults to the system clock.
+	Clock Clock
+}
+
+// withDefaults returns a copy of cfg with zero values replaced by defaults.
+func (cfg CircuitBreakerConfig) withDefaults() CircuitBreakerConfig {
+	if cfg.FailureRatio == 0 {
+		cfg.FailureRatio = 0.5
+	}
+	if cfg.MinRequests == 0 {
+		cfg.MinRequests = 20
+	}
+	if cfg.Window == 0 {
+		cfg.Window = 10 * time.Second
+	}
+	if cfg.SlowCallThreshold == 0 {
+		cfg.SlowCallThreshold = 100 * time.Millisecond
+	}
+	if cfg.OpenTimeout == 0 {
+		cfg.OpenTimeout = 5 * time.Second
+	}
+	if cfg.HalfOpenProbes == 0 {
+		cfg.HalfOpenProbes = 3
+	}
+	cfg.Clock = clockOrSystem(cfg.Clock)
+	return cfg
+}
+
+// problems returns a description of every invalid field in cfg, prefixed with name.
+func (cfg CircuitBreakerConfig) problems(name string) []string {
+	var problems []string
+	if cfg.FailureRatio < 0 || cfg.FailureRatio > 1 {
+		problems = append(problems, fmt.Sprintf("%s.FailureRatio must be in (0, 1], or 0 for the default, got %g", name, cfg.FailureRatio))
+	}
+	if cfg.MinRequests < 0 {
+		problems = append(problems, fmt.Sprintf("%s.MinRequests must not be negative, got %d", name, cfg.MinRequests))
+	}
+	if cfg.Window < 0 {
+		problems = append(problems, fmt.Sprintf("%s.Window must not be negative, got %s", name, cfg.Window))
+	}
+	if cfg.SlowCallThreshold < 0 {
+		problems = append(problems, fmt.Sprintf("%s.SlowCallThreshold must not be negative, got %s", name, cfg.SlowCallThreshold))
+	}
+	if cfg.OpenTimeout < 0 {
+		problems = append(problems, fmt.Sprintf("%s.OpenTimeout must not be negative, got %s", name, cfg.OpenTimeout))
+	}
+	if cfg.HalfOpenProbes < 0 {
+		problems = append(problems, fmt.Sprintf("%s.HalfOpenProbes must not be negative, got %d", name, cfg.HalfOpenProbes))
+	}
+	return problems
+}
+
+// CircuitBreakerStorage wraps a Storage with a circuit breaker.
+// When the wrapped storage fails or is slow too often, the circuit opens and calls fail
+// immediately with ErrCircuitOpen, so the rate limiter switches to its fallback storage
+// without waiting on the broken backend. After OpenTimeout a few probe calls are let
+// through, and the circuit closes again once they succeed.
+type CircuitBreakerStorage struct {
+	storage Storage
+	cfg     CircuitBreakerConfig
+
+	mu          sync.Mutex
+	state       CircuitState
+	windowStart time.Time
+	calls       int
+	failures    int
+	openedAt    time.Time
+	probes      int
+	successes   int
+}
+
+// NewCircuitBreakerStorage wraps storage with a circuit breaker configured by cfg.
+// The result doesn't implement AtomicStorage even if storage does, so token checks
+// read and write the bucket in separate calls; use NewAtomicCircuitBreakerStorage to
+// keep them atomic.
+func NewCircuitBreakerStorage(storage Storage, cfg CircuitBreakerConfig) *CircuitBreakerStorage {
+	cfg = cfg.withDefaults()
+	return &CircuitBreakerStorage{
+		storage:     storage,
+		cfg:         cfg,
+		windowStart: cfg.Clock.Now(),
+	}
+}
+
+// AtomicCircuitBreakerStorage is a CircuitBreakerStorage around an AtomicStorage.
+// It implements AtomicStorage, passing TakeToken through the circuit breaker.
+type AtomicCircuitBreakerStorage struct {
+	*CircuitBreakerStorage
+	atomic AtomicStorage
+}
+
+// NewAtomicCircuitBreakerStorage wraps storage with a circuit breaker configured by cfg,
+// keeping its atomic TakeToken.
+func NewAtomicCircuitBreakerStorage(storage AtomicStorage, cfg CircuitBreakerConfig) *AtomicCircuitBreakerStorage {
+	return &AtomicCircuitBreakerStorage{
+		CircuitBreakerStorage: NewCircuitBreakerStorage(storage, cfg),
+		atomic:                storage,
+	}
+}
+
+// TakeToken takes a token from the bucket in the wrapped storage unless the circuit is open.
+func (cb *AtomicCircuitBreakerStorage) TakeToken(ctx context.Context, key string, capacity int,
+	rate float64, expiry time.Duration) (tokens float64, allowed bool, err error) {
+	err = cb.do(func() (err error) {
+		tokens, allowed, err = cb.atomic.TakeToken(ctx, key, capacity, rate, expiry)
+		return err
+	})
+	return tokens, allowed, err
+}
+
+// circuitBreakerOf returns the circuit breaker of storage, if storage is a
+// CircuitBreakerStorage or an AtomicCircuitBreakerStorage.
+func circuitBreakerOf(storage Storage) (*CircuitBreakerStorage, bool) {
+	switch cb := storage.(type) {
+	case *CircuitBreakerStorage:
+		return cb, true
+	case *AtomicCircuitBreakerStorage:
+		return cb.CircuitBreakerStorage, true
+	default:
+		return nil, false
+	}
+}
+
+// State returns the current state of the circuit.
+func (cb *CircuitBreakerStorage) State() CircuitState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	return cb.currentState(cb.cfg.Clock.Now())
+}
+
+// GetBucket retrieves the bucket from the wrapped storage unless the circuit is open.
+func (cb *CircuitBreakerStorage) GetBucket(ctx context.Context, key string) (float64, time.Time, error) {
+	var (
+		tokens     float64
+		lastUpdate time.Time
+	)
+	err := cb.do(func() (err error) {
+		tokens, lastUpdate, err = cb.storage.GetBucket(ctx, key)
+		return err
+	})
+	return tokens, lastUpdate, err
+}
+
+// UpdateBucket updates the bucket in the wrapped storage unless the circuit is open.
+func (cb *CircuitBreakerStorage) UpdateBucket(ctx context.Context, key string, tokens float64, expiry time.Duration) error {
+	return cb.do(func() error {
+		return cb.storage.UpdateBucket(ctx, key, tokens, expiry)
+	})
+}
+
+// do makes call, a call to the wrapped storage's backend, unless the circuit is open,
+// and records its outcome.
+func (cb *CircuitBreakerStorage) do(call func() error) error {
+	if err := cb.before(); err != nil {
+		return err
+	}
+
+	start := cb.cfg.Clock.Now()
+	err := call()
+	cb.after(err, cb.cfg.Clock.Now().Sub(start))
+	return err
+}
+
+// currentState returns the state at now, moving an open circuit to half-open once
+// OpenTimeout has passed. The caller must hold cb.mu.
+func (cb *CircuitBreakerStorage) currentState(now time.Time) CircuitState {
+	if cb.state == CircuitOpen && now.Sub(cb.openedAt) >= cb.cfg.OpenTimeout {
+		return CircuitHalfOpen
+	}
+	return cb.state
+}
+
+// before decides whether a call may go through to the wrapped storage.
+func (cb *CircuitBreakerStorage) before() error {
+	cb.mu.Lock()
+
+	now := cb.cfg.Clock.Now()
+	from := cb.state
+	state := cb.currentState(now)
+	if state != from {
+		cb.setState(state, now)
+	}
+
+	var err error
+	switch state {
+	case CircuitOpen:
+		err = ErrCircuitOpen
+	case CircuitHalfOpen:
+		if cb.probes >= cb.cfg.HalfOpenProbes {
+			err = ErrCircuitOpen
+		} else {
+			cb.probes++
+		}
+	}
+	cb.mu.Unlock()
+
+	if state != from {
+		cb.notify(from, state)
+	}
+	return err
+}
+
+// after records the outcome of a call that went through to the wrapped storage.
+func (cb *CircuitBreakerStorage) after(err error, latency time.Duration) {
+	// Cancelled requests say nothing about the health of the storage
+	ignored := errors.Is(err, context.Canceled)
+	failed := errors.Is(err, ErrStorageUnavailable) || errors.Is(err, ErrTimeout) ||
+		latency > cb.cfg.SlowCallThreshold
+
+	cb.mu.Lock()
+
+	now := cb.cfg.Clock.Now()
+	from, to := cb.state, cb.state
+
+	switch cb.state {
+	case CircuitHalfOpen:
+		if cb.probes > 0 {
+			cb.probes--
+		}
+		if ignored {
+			break
+		}
+		if failed {
+			to = CircuitOpen
+		} else if cb.successes++; cb.successes >= cb.cfg.HalfOpenProbes {
+			to = CircuitClosed
+		}
+	case CircuitClosed:
+		if ignored {
+			break
+		}
+		if now.Sub(cb.windowStart) >= cb.cfg.Window {
+			cb.windowStart, cb.calls, cb.failures = now, 0, 0
+		}
+		cb.calls++
+		if failed {
+			cb.failures++
+		}
+		if cb.calls >= cb.cfg.MinRequests &&
+			float64(cb.failures)/float64(cb.calls) >= cb.cfg.FailureRatio {
+			to = CircuitOpen
+		}
+	}
+
+	if to != from {
+		cb.setState(to, now)
+	}
+	cb.mu.Unlock()
+
+	if to != from {
+		cb.notify(from, to)
+	}
+}
+
+// setState moves the circuit to state and resets the counters for it.
+// It returns the previous state. The caller must hold cb.mu.
+func (cb *CircuitBreakerStorage) setState(state CircuitState, now time.Time) CircuitState {
+	from := cb.state
+	cb.state = state
+	cb.probes, cb.successes = 0, 0
+
+	switch state {
+	case CircuitOpen:
+		cb.openedAt = now
+	case CircuitClosed:
+		cb.windowStart, cb.calls, cb.failures = now, 0, 0
+	}
+	return from
+}
+
+func (cb *CircuitBreakerStorage) notify(from, to CircuitState) {
+	if cb.cfg.OnStateChange != nil {
+		cb.cfg.OnStateChange(from, to)
+	}
+}
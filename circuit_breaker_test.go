@@ -0,0 +1,183 @@
+package rateLimiter_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	rl "github.com/Popoola-Opeyemi/rateLimiter"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// failingHook fails every Redis call while fail is set, and counts the calls made.
+type failingHook struct {
+	fail  atomic.Bool
+	calls atomic.Int64
+}
+
+func (h *failingHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+func (h *failingHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		h.calls.Add(1)
+		if h.fail.Load() {
+			return errors.New("connection refused")
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (h *failingHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		h.calls.Add(1)
+		if h.fail.Load() {
+			return errors.New("connection refused")
+		}
+		return next(ctx, cmds)
+	}
+}
+
+func TestOpenCircuitSkipsSecurityChecks(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	hook := &failingHook{}
+	client.AddHook(hook)
+
+	cfg := baseConfig()
+	cfg.Redis = client
+	cfg.GlobalSecurity = rl.SecurityConfig{MaxFailedAttempts: 3, BlockDuration: time.Minute}
+	cfg.CircuitBreaker = &rl.CircuitBreakerConfig{MinRequests: 1, OpenTimeout: time.Hour}
+	app := newUseApp(t, cfg)
+
+	hook.fail.Store(true)
+	get(t, app, "/api/login") // opens the circuit
+
+	calls := hook.calls.Load()
+	for i := 0; i < 5; i++ {
+		if status, _ := get(t, app, "/api/login"); status != fiber.StatusOK {
+			t.Fatalf("request %d: status %d, want 200 from the fallback storage", i+1, status)
+		}
+	}
+	if made := hook.calls.Load() - calls; made != 0 {
+		t.Fatalf("%d Redis calls made while the circuit was open, want 0", made)
+	}
+}
+
+// corruptStorage is a reachable storage whose buckets can't be decoded.
+type corruptStorage struct{}
+
+func (corruptStorage) GetBucket(ctx context.Context, key string) (float64, time.Time, error) {
+	return 0, time.Time{}, fmt.Errorf("GetBucket %s: %w", key, rl.ErrCorruptState)
+}
+
+func (corruptStorage) UpdateBucket(ctx context.Context, key string, tokens float64, expiry time.Duration) error {
+	return nil
+}
+
+func TestCorruptStateIsNotAStorageFailure(t *testing.T) {
+	var fallbacks atomic.Int64
+	cfg := baseConfig()
+	cfg.Storage = corruptStorage{}
+	cfg.CircuitBreaker = &rl.CircuitBreakerConfig{MinRequests: 1, OpenTimeout: time.Hour}
+	cfg.Events = rl.NewEventBus()
+	cfg.Events.On(func(rl.Event) { fallbacks.Add(1) }, rl.EventStorageFallback)
+	app := newUseApp(t, cfg)
+
+	for i := 0; i < 5; i++ {
+		status, remaining := get(t, app, "/")
+		if status != fiber.StatusOK || remaining != "99" {
+			t.Fatalf("request %d: status %d, remaining %q; want 200 and 99 from a reset bucket", i+1, status, remaining)
+		}
+	}
+	if n := fallbacks.Load(); n != 0 {
+		t.Fatalf("%d storage fallback events for corrupt buckets, want 0", n)
+	}
+}
+
+// flakyStorage is an in-memory storage that fails with ErrStorageUnavailable while fail is set.
+type flakyStorage struct {
+	*rl.InMemoryStorage
+	fail atomic.Bool
+}
+
+func (s *flakyStorage) GetBucket(ctx context.Context, key string) (float64, time.Time, error) {
+	if s.fail.Load() {
+		return 0, time.Time{}, rl.ErrStorageUnavailable
+	}
+	return s.InMemoryStorage.GetBucket(ctx, key)
+}
+
+func (s *flakyStorage) TakeToken(ctx context.Context, key string, capacity int, rate float64,
+	expiry time.Duration) (float64, bool, error) {
+	if s.fail.Load() {
+		return 0, false, rl.ErrStorageUnavailable
+	}
+	return s.InMemoryStorage.TakeToken(ctx, key, capacity, rate, expiry)
+}
+
+func TestSmallFailureRatioOpensOnAnyFailure(t *testing.T) {
+	storage := &flakyStorage{InMemoryStorage: rl.NewInMemoryStorageWithConfig(rl.InMemoryConfig{JanitorInterval: -1})}
+	breaker := rl.NewCircuitBreakerStorage(storage, rl.CircuitBreakerConfig{
+		FailureRatio: 0.001,
+		MinRequests:  4,
+		OpenTimeout:  time.Hour,
+	})
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		breaker.GetBucket(ctx, "key")
+	}
+	storage.fail.Store(true)
+	breaker.GetBucket(ctx, "key")
+
+	if state := breaker.State(); state != rl.CircuitOpen {
+		t.Fatalf("state after 1 failure in 4 calls: %s, want open", state)
+	}
+}
+
+func TestFailureRatioAboveOneIsRejected(t *testing.T) {
+	cfg := baseConfig()
+	cfg.CircuitBreaker = &rl.CircuitBreakerConfig{FailureRatio: 1.5}
+
+	var invalid *rl.ValidationError
+	if err := cfg.Validate(); !errors.As(err, &invalid) {
+		t.Fatalf("Validate with FailureRatio 1.5: got %v, want a ValidationError", err)
+	}
+}
+
+// countingStorage is an in-memory storage that counts its TakeToken calls.
+type countingStorage struct {
+	*rl.InMemoryStorage
+	takes atomic.Int64
+}
+
+func (s *countingStorage) TakeToken(ctx context.Context, key string, capacity int, rate float64,
+	expiry time.Duration) (float64, bool, error) {
+	s.takes.Add(1)
+	return s.InMemoryStorage.TakeToken(ctx, key, capacity, rate, expiry)
+}
+
+func TestCircuitBreakerKeepsTakeTokenAtomic(t *testing.T) {
+	storage := &countingStorage{InMemoryStorage: rl.NewInMemoryStorageWithConfig(rl.InMemoryConfig{JanitorInterval: -1})}
+	cfg := baseConfig()
+	cfg.Storage = storage
+	cfg.CircuitBreaker = &rl.CircuitBreakerConfig{}
+	app := newUseApp(t, cfg)
+
+	for i := 0; i < 3; i++ {
+		get(t, app, "/")
+	}
+	if takes := storage.takes.Load(); takes != 3 {
+		t.Fatalf("%d TakeToken calls through the circuit breaker, want 3", takes)
+	}
+}
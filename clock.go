@@ -0,0 +1,32 @@
+package rateLimiter
+
+import "time"
+
+// Clock tells the rate limiter and its storages the current time.
+// The default reads the system clock; tests can substitute a fake clock, such as
+// the one in the clocktest package, to advance time without sleeping.
+// Implementations must be safe for concurrent use.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the Clock backed by time.Now.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// SystemClock returns the Clock backed by time.Now, which storages use when their
+// configuration sets none.
+func SystemClock() Clock {
+	return systemClock{}
+}
+
+// clockOrSystem returns clock, or the system clock if clock is nil.
+func clockOrSystem(clock Clock) Clock {
+	if clock == nil {
+		return systemClock{}
+	}
+	return clock
+}
@@ -0,0 +1,58 @@
+// Package clocktest provides a fake rateLimiter.Clock for deterministic tests.
+//
+// Pass the same Clock to RateLimiterConfig.Clock and to the storages, then advance it
+// to refill buckets, expire them or open and close circuit breakers without sleeping:
+//
+//	clock := clocktest.New(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+//	limiter, err := rateLimiter.NewLimiter(rateLimiter.RateLimiterConfig{
+//		Clock: clock,
+//		// ...
+//	})
+//	// exhaust the bucket, then
+//	clock.Advance(time.Second)
+package clocktest
+
+import (
+	"sync"
+	"time"
+
+	rateLimiter "github.com/Popoola-Opeyemi/rateLimiter"
+)
+
+var _ rateLimiter.Clock = (*Clock)(nil)
+
+// Clock is a rateLimiter.Clock that only moves when told to.
+// It is safe for concurrent use.
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// New returns a Clock set to start.
+func New(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+// Now returns the clock's current time.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// Advance moves the clock forward by d.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+// Set moves the clock to t, which may be earlier than its current time.
+func (c *Clock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = t
+}
@@ -0,0 +1,344 @@
+package rateLimiter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ConfigFormat identifies the encoding of a configuration file.
+type ConfigFormat string
+
+const (
+	// FormatYAML is used for files ending in .yaml or .yml. It is available once the
+	// yamlconfig package is imported.
+	FormatYAML ConfigFormat = "yaml"
+
+	// FormatJSON is used for files ending in .json
+	FormatJSON ConfigFormat = "json"
+)
+
+// ConfigDecoder decodes a configuration document into spec. It should reject unknown
+// fields so that typos don't silently fall back to defaults.
+type ConfigDecoder func(data []byte, spec *ConfigSpec) error
+
+// configFormats holds the decoders of the registered formats and the file extensions
+// they are used for.
+var configFormats = struct {
+	sync.RWMutex
+	decoders   map[ConfigFormat]ConfigDecoder
+	extensions map[string]ConfigFormat
+}{
+	decoders:   map[ConfigFormat]ConfigDecoder{FormatJSON: decodeJSON},
+	extensions: map[string]ConfigFormat{".json": FormatJSON},
+}
+
+// RegisterConfigFormat makes format available to ParseConfig, and to LoadConfigFile for
+// files with one of the given extensions, such as ".yaml". It is called by packages
+// providing a format when they are imported, like yamlconfig for FormatYAML:
+//
+//	import _ "github.com/Popoola-Opeyemi/rateLimiter/yamlconfig"
+func RegisterConfigFormat(format ConfigFormat, decode ConfigDecoder, extensions ...string) {
+	configFormats.Lock()
+	defer configFormats.Unlock()
+
+	configFormats.decoders[format] = decode
+	for _, ext := range extensions {
+		configFormats.extensions[strings.ToLower(ext)] = format
+	}
+}
+
+// configDecoder returns the decoder registered for format.
+func configDecoder(format ConfigFormat) (ConfigDecoder, error) {
+	configFormats.RLock()
+	defer configFormats.RUnlock()
+
+	decode, ok := configFormats.decoders[format]
+	switch {
+	case ok:
+		return decode, nil
+	case format == FormatYAML:
+		return nil, errors.New(`config format "yaml" is not registered; import github.com/Popoola-Opeyemi/rateLimiter/yamlconfig`)
+	default:
+		return nil, fmt.Errorf("unsupported config format %q", format)
+	}
+}
+
+// decodeJSON is the ConfigDecoder of FormatJSON.
+func decodeJSON(data []byte, spec *ConfigSpec) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(spec)
+}
+
+// ConfigSpec is the serializable part of a RateLimiterConfig.
+// It holds the settings that can be loaded from a YAML or JSON file and swapped at runtime.
+// Settings that are code (GetUserID, GetUserTier) or connections (Redis) are not part of it.
+//
+// Sections that are left out of the file keep their current value when the spec is applied.
+//
+// Example (YAML):
+//
+//	tiers:
+//	  free:
+//	    max_requests: 1000
+//	    burst_capacity: 50
+//	    tokens_per_second: 1.0
+//	  pro:
+//	    max_requests: 5000
+//	    burst_capacity: 200
+//	    tokens_per_second: 5.0
+//	    websocket_allowed: true
+//	default_policy:
+//	  max_requests: 500
+//	  burst_capacity: 25
+//	  tokens_per_second: 0.5
+//	routes:
+//	  /api/login:
+//	    burst_capacity: 5
+//	    tokens_per_second: 0.1
+//	    shadow:
+//	      burst_capacity: 3
+//	      tokens_per_second: 0.05
+//	skip_paths: ["/metrics", "/health"]
+//	security:
+//	  whitelist_ips: ["127.0.0.1"]
+//	  max_failed_attempts: 5
+//	  block_duration: 15m
+type ConfigSpec struct {
+	// Tiers replaces RateLimiterConfig.TierPolicy
+	Tiers map[string]PolicySpec `json:"tiers,omitempty" yaml:"tiers,omitempty"`
+
+	// DefaultPolicy replaces RateLimiterConfig.DefaultPolicy
+	DefaultPolicy *PolicySpec `json:"default_policy,omitempty" yaml:"default_policy,omitempty"`
+
+	// Routes replaces RateLimiterConfig.RoutePolicy
+	Routes map[string]PolicySpec `json:"routes,omitempty" yaml:"routes,omitempty"`
+
+	// SkipPaths replaces RateLimiterConfig.SkipPaths
+	SkipPaths []string `json:"skip_paths,omitempty" yaml:"skip_paths,omitempty"`
+
+	// Security replaces RateLimiterConfig.GlobalSecurity
+	Security *SecuritySpec `json:"security,omitempty" yaml:"security,omitempty"`
+
+	// Headers replaces RateLimiterConfig.HeaderMode
+	Headers HeaderMode `json:"headers,omitempty" yaml:"headers,omitempty"`
+}
+
+// PolicySpec is the file representation of a Policy.
+type PolicySpec struct {
+	MaxRequests      int          `json:"max_requests" yaml:"max_requests"`
+	BurstCapacity    int          `json:"burst_capacity" yaml:"burst_capacity"`
+	TokensPerSecond  float64      `json:"tokens_per_second" yaml:"tokens_per_second"`
+	WebSocketAllowed bool         `json:"websocket_allowed" yaml:"websocket_allowed"`
+	FailureMode      FailureMode  `json:"failure_mode,omitempty" yaml:"failure_mode,omitempty"`
+	DryRun           bool         `json:"dry_run,omitempty" yaml:"dry_run,omitempty"`
+	Shadow           *PolicySpec  `json:"shadow,omitempty" yaml:"shadow,omitempty"`
+	Security         SecuritySpec `json:"security" yaml:"security"`
+}
+
+// SecuritySpec is the file representation of a SecurityConfig.
+type SecuritySpec struct {
+	BypassTokens          []string `json:"bypass_tokens,omitempty" yaml:"bypass_tokens,omitempty"`
+	WhitelistIPs          []string `json:"whitelist_ips,omitempty" yaml:"whitelist_ips,omitempty"`
+	RequireAuthentication bool     `json:"require_authentication" yaml:"require_authentication"`
+	MaxFailedAttempts     int      `json:"max_failed_attempts" yaml:"max_failed_attempts"`
+	BlockDuration         Duration `json:"block_duration" yaml:"block_duration"`
+}
+
+// Duration is a time.Duration that is written as a string such as "15m" or "1h30m"
+// in configuration files.
+type Duration time.Duration
+
+// MarshalJSON encodes the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON accepts a duration string or a number of nanoseconds.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var n int64
+		if err := json.Unmarshal(data, &n); err != nil {
+			return fmt.Errorf("invalid duration %s", data)
+		}
+		*d = Duration(n)
+		return nil
+	}
+	return d.parse(s)
+}
+
+// MarshalYAML encodes the duration as a string.
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+// UnmarshalText accepts a duration string such as "15m". It is used by text-based
+// formats other than JSON, such as YAML.
+func (d *Duration) UnmarshalText(text []byte) error {
+	return d.parse(string(text))
+}
+
+func (d *Duration) parse(s string) error {
+	if s == "" {
+		*d = 0
+		return nil
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %w", s, err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// LoadConfigFile reads and validates a configuration file.
+// The format is chosen from the file extension: .json, .yaml or .yml once the
+// yamlconfig package is imported, or the extensions of another registered format.
+func LoadConfigFile(path string) (*ConfigSpec, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	configFormats.RLock()
+	format, ok := configFormats.extensions[ext]
+	configFormats.RUnlock()
+	if !ok {
+		switch ext {
+		case ".yaml", ".yml":
+			format = FormatYAML
+		default:
+			return nil, fmt.Errorf("rate limiter config %s: unsupported file extension", path)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("rate limiter config: %w", err)
+	}
+
+	spec, err := ParseConfig(data, format)
+	if err != nil {
+		return nil, fmt.Errorf("rate limiter config %s: %w", path, err)
+	}
+	return spec, nil
+}
+
+// ParseConfig decodes and validates a configuration document.
+// Unknown fields are rejected so that typos don't silently fall back to defaults.
+func ParseConfig(data []byte, format ConfigFormat) (*ConfigSpec, error) {
+	decode, err := configDecoder(format)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := &ConfigSpec{}
+	if err := decode(data, spec); err != nil {
+		return nil, err
+	}
+
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// Validate checks every policy in the spec and returns a *ValidationError listing all problems found.
+func (s *ConfigSpec) Validate() error {
+	var problems []string
+
+	for _, name := range sortedKeys(s.Tiers) {
+		problems = append(problems, s.Tiers[name].Policy().problems("tiers."+name)...)
+	}
+	if s.DefaultPolicy != nil {
+		problems = append(problems, s.DefaultPolicy.Policy().problems("default_policy")...)
+	}
+	for _, route := range sortedKeys(s.Routes) {
+		if !strings.HasPrefix(route, "/") {
+			problems = append(problems, fmt.Sprintf("routes.%s: route must start with /", route))
+		}
+		problems = append(problems, s.Routes[route].Policy().problems("routes."+route)...)
+	}
+	if s.Security != nil {
+		security := s.Security.SecurityConfig()
+		problems = append(problems, security.problems("security")...)
+	}
+	if !s.Headers.valid() {
+		problems = append(problems, fmt.Sprintf("headers: %q is not one of %q, %q, %q or %q",
+			s.Headers, HeadersLegacy, HeadersIETF, HeadersBoth, HeadersNone))
+	}
+
+	return newValidationError(problems)
+}
+
+// Apply returns a copy of cfg with the sections present in the spec replaced.
+func (s *ConfigSpec) Apply(cfg RateLimiterConfig) RateLimiterConfig {
+	if s.Tiers != nil {
+		cfg.TierPolicy = make(map[string]Policy, len(s.Tiers))
+		for name, p := range s.Tiers {
+			cfg.TierPolicy[name] = p.Policy()
+		}
+	}
+	if s.DefaultPolicy != nil {
+		cfg.DefaultPolicy = s.DefaultPolicy.Policy()
+	}
+	if s.Routes != nil {
+		cfg.RoutePolicy = make(map[string]Policy, len(s.Routes))
+		for route, p := range s.Routes {
+			cfg.RoutePolicy[route] = p.Policy()
+		}
+	}
+	if s.SkipPaths != nil {
+		cfg.SkipPaths = append([]string(nil), s.SkipPaths...)
+	}
+	if s.Security != nil {
+		cfg.GlobalSecurity = s.Security.SecurityConfig()
+	}
+	if s.Headers != "" {
+		cfg.HeaderMode = s.Headers
+	}
+	return cfg
+}
+
+// Policy converts the spec into a Policy.
+func (ps PolicySpec) Policy() Policy {
+	policy := Policy{
+		MaxRequests:      ps.MaxRequests,
+		BurstCapacity:    ps.BurstCapacity,
+		TokensPerSecond:  ps.TokensPerSecond,
+		WebSocketAllowed: ps.WebSocketAllowed,
+		FailureMode:      ps.FailureMode,
+		DryRun:           ps.DryRun,
+		Security:         ps.Security.SecurityConfig(),
+	}
+	if ps.Shadow != nil {
+		shadow := ps.Shadow.Policy()
+		policy.Shadow = &shadow
+	}
+	return policy
+}
+
+// SecurityConfig converts the spec into a SecurityConfig.
+func (ss SecuritySpec) SecurityConfig() SecurityConfig {
+	return SecurityConfig{
+		BypassTokens:          append([]string(nil), ss.BypassTokens...),
+		WhitelistIPs:          append([]string(nil), ss.WhitelistIPs...),
+		RequireAuthentication: ss.RequireAuthentication,
+		MaxFailedAttempts:     ss.MaxFailedAttempts,
+		BlockDuration:         time.Duration(ss.BlockDuration),
+	}
+}
+
+func sortedKeys(m map[string]PolicySpec) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,25 @@
+package rateLimiter_test
+
+import (
+	"strings"
+	"testing"
+
+	rl "github.com/Popoola-Opeyemi/rateLimiter"
+)
+
+func TestParseConfigYAMLNeedsYAMLConfig(t *testing.T) {
+	_, err := rl.ParseConfig([]byte("skip_paths: [/health]\n"), rl.FormatYAML)
+	if err == nil || !strings.Contains(err.Error(), "yamlconfig") {
+		t.Fatalf("ParseConfig(yaml) without yamlconfig: err = %v, want a hint to import it", err)
+	}
+}
+
+func TestParseConfigJSON(t *testing.T) {
+	spec, err := rl.ParseConfig([]byte(`{"security": {"max_failed_attempts": 3, "block_duration": "90s"}}`), rl.FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg := spec.Security.SecurityConfig(); cfg.MaxFailedAttempts != 3 || cfg.BlockDuration.Seconds() != 90 {
+		t.Fatalf("security = %+v", cfg)
+	}
+}
@@ -0,0 +1,222 @@
+package rateLimiter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// publishConfigScript stores a new config document and bumps its version in a single
+// atomic step, so readers always see a version together with the document it belongs to.
+var publishConfigScript = redis.NewScript(`
+local version = redis.call('HINCRBY', KEYS[1], 'version', 1)
+redis.call('HSET', KEYS[1], 'spec', ARGV[1])
+return version
+`)
+
+// RedisConfigSource distributes a versioned ConfigSpec to every Limiter sharing a Redis instance.
+// The current document is kept in a Redis hash and each new version is announced on a
+// pub/sub channel, so all replicas switch to it without a file rollout.
+type RedisConfigSource struct {
+	client  redis.UniversalClient
+	key     string
+	channel string
+
+	// ResyncInterval is how often watchers re-read the stored version in case a
+	// pub/sub message was missed during a reconnect. Defaults to 30 seconds.
+	ResyncInterval time.Duration
+}
+
+// NewRedisConfigSource creates a config source whose keys are namespaced with keyPrefix.
+// The document is stored at "<keyPrefix>:config" and changes are announced on
+// "<keyPrefix>:config:updates". The version and the document share a single hash,
+// so publishing is atomic on Redis Cluster as well.
+func NewRedisConfigSource(client redis.UniversalClient, keyPrefix string) *RedisConfigSource {
+	return &RedisConfigSource{
+		client:         client,
+		key:            keyPrefix + ":config",
+		channel:        keyPrefix + ":config:updates",
+		ResyncInterval: 30 * time.Second,
+	}
+}
+
+// Publish validates spec, stores it as the next version and notifies all watchers.
+// It returns the version assigned to the document.
+func (s *RedisConfigSource) Publish(ctx context.Context, spec *ConfigSpec) (int64, error) {
+	if err := spec.Validate(); err != nil {
+		return 0, err
+	}
+
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return 0, err
+	}
+
+	version, err := publishConfigScript.Run(ctx, s.client, []string{s.key}, data).Int64()
+	if err != nil {
+		return 0, err
+	}
+
+	// Watchers also resync periodically, so a failed notification only delays the switch
+	if err := s.client.Publish(ctx, s.channel, version).Err(); err != nil {
+		return version, err
+	}
+	return version, nil
+}
+
+// Load returns the current version and document.
+// A version of 0 and a nil spec mean nothing has been published yet.
+func (s *RedisConfigSource) Load(ctx context.Context) (int64, *ConfigSpec, error) {
+	values, err := s.client.HMGet(ctx, s.key, "version", "spec").Result()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	rawVersion, _ := values[0].(string)
+	rawSpec, _ := values[1].(string)
+	if rawVersion == "" || rawSpec == "" {
+		return 0, nil, nil
+	}
+
+	version, err := strconv.ParseInt(rawVersion, 10, 64)
+	if err != nil {
+		return 0, nil, fmt.Errorf("rate limiter config version %q: %w", rawVersion, err)
+	}
+
+	spec, err := ParseConfig([]byte(rawSpec), FormatJSON)
+	if err != nil {
+		return version, nil, fmt.Errorf("rate limiter config version %d: %w", version, err)
+	}
+	return version, spec, nil
+}
+
+// ConfigVersion returns the version of the last document applied from a RedisConfigSource,
+// or 0 if none has been applied.
+func (l *Limiter) ConfigVersion() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.version
+}
+
+// WatchRedis applies the current document from src and then follows every newly published
+// version. It returns once the subscription is established; watching stops when ctx is cancelled.
+//
+// Each version is applied on top of the configuration the Limiter had when WatchRedis was
+// called, never on top of a previous version. This guarantees that a Limiter never serves a
+// mix of policies from two versions.
+//
+// Versions only grow, so a stored version older than the one in use means the hash was
+// reset or reseeded, for example by FLUSHDB or a new deployment publishing from version 1.
+// It is logged and applied. A reset store that is republished up to exactly the version
+// in use can't be told apart from it, and is picked up with the next version.
+//
+// onReload, if not nil, is called after every apply attempt with the version and its result.
+// A version that can't be loaded or applied is reported once, not on every resync.
+func (l *Limiter) WatchRedis(ctx context.Context, src *RedisConfigSource, onReload func(version int64, err error)) error {
+	pubsub := src.client.Subscribe(ctx, src.channel)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return err
+	}
+
+	base := l.Config()
+
+	// rejected is the last version that couldn't be loaded or applied, so an invalid
+	// document is reported once rather than on every resync
+	var rejected int64
+	resync := func() {
+		version, spec, err := src.Load(ctx)
+		if err == nil && spec == nil {
+			return
+		}
+		applied := false
+		if err == nil {
+			applied, err = l.applyVersion(base, spec, version)
+		}
+		switch {
+		case err != nil && version != 0 && version == rejected:
+			// Already reported
+		case err != nil:
+			rejected = version
+			l.notifyRedisReload(version, onReload, err)
+		case applied:
+			rejected = 0
+			l.notifyRedisReload(version, onReload, nil)
+		}
+	}
+	resync()
+
+	interval := src.ResyncInterval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+
+	go func() {
+		defer pubsub.Close()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-messages:
+				// The message only carries the version number; the document itself is
+				// always read back from the hash so a newer version is never skipped.
+				resync()
+			case <-ticker.C:
+				resync()
+			}
+		}
+	}()
+	return nil
+}
+
+// notifyRedisReload logs the result of applying a version from a RedisConfigSource and
+// passes it on to onReload.
+func (l *Limiter) notifyRedisReload(version int64, onReload func(int64, error), err error) {
+	log := l.Config().log()
+	if err != nil {
+		log.log(context.Background(), LogConfigReloadError, "Rate limiter configuration reload failed",
+			slog.String("source", "redis"), slog.Int64("version", version), slog.Any("error", err))
+	} else {
+		log.log(context.Background(), LogConfigReload, "Rate limiter configuration reloaded",
+			slog.String("source", "redis"), slog.Int64("version", version))
+	}
+	if onReload != nil {
+		onReload(version, err)
+	}
+}
+
+// applyVersion swaps in spec applied on top of base if version differs from the
+// version currently in use. It reports whether the configuration was replaced.
+func (l *Limiter) applyVersion(base RateLimiterConfig, spec *ConfigSpec, version int64) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if version == l.version {
+		return false, nil
+	}
+
+	cfg := spec.Apply(base)
+	if err := cfg.Validate(); err != nil {
+		return false, err
+	}
+	if version < l.version {
+		l.config.Load().log().log(context.Background(), LogConfigReload,
+			"Rate limiter configuration version went back, the config source was reset",
+			slog.String("source", "redis"), slog.Int64("version", version),
+			slog.Int64("previous_version", l.version))
+	}
+	l.storeConfig(cfg)
+	l.version = version
+	return true, nil
+}
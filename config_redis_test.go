@@ -0,0 +1,113 @@
+package rateLimiter_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	rl "github.com/Popoola-Opeyemi/rateLimiter"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// reloadResult is a version applied by WatchRedis and its result.
+type reloadResult struct {
+	version int64
+	err     error
+}
+
+// watchRedis starts watching src with a new Limiter and returns it with the channel its
+// reload results are sent to.
+func watchRedis(t *testing.T, src *rl.RedisConfigSource) (*rl.Limiter, chan reloadResult) {
+	t.Helper()
+
+	limiter, err := rl.NewLimiter(baseConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { limiter.Close() })
+
+	results := make(chan reloadResult, 100)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	err = limiter.WatchRedis(ctx, src, func(version int64, err error) { results <- reloadResult{version, err} })
+	if err != nil {
+		t.Fatal(err)
+	}
+	return limiter, results
+}
+
+func nextReload(t *testing.T, results chan reloadResult) reloadResult {
+	t.Helper()
+
+	select {
+	case r := <-results:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reload reported")
+		return reloadResult{}
+	}
+}
+
+func TestWatchRedisAppliesResetVersions(t *testing.T) {
+	mr := miniredis.RunT(t)
+	src := rl.NewRedisConfigSource(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "rl")
+	ctx := context.Background()
+
+	for _, path := range []string{"/v1", "/v2"} {
+		if _, err := src.Publish(ctx, &rl.ConfigSpec{SkipPaths: []string{path}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	limiter, results := watchRedis(t, src)
+	if r := nextReload(t, results); r.version != 2 || r.err != nil {
+		t.Fatalf("initial reload: %+v, want version 2", r)
+	}
+
+	// A new deployment starts counting from 1 again
+	mr.FlushAll()
+	if _, err := src.Publish(ctx, &rl.ConfigSpec{SkipPaths: []string{"/reset"}}); err != nil {
+		t.Fatal(err)
+	}
+	if r := nextReload(t, results); r.version != 1 || r.err != nil {
+		t.Fatalf("reload after the reset: %+v, want version 1", r)
+	}
+	if skip := limiter.Config().SkipPaths; len(skip) != 1 || skip[0] != "/reset" {
+		t.Fatalf("SkipPaths = %q, want [/reset]", skip)
+	}
+}
+
+func TestWatchRedisReportsInvalidVersionOnce(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	src := rl.NewRedisConfigSource(client, "rl")
+	ctx := context.Background()
+
+	if _, err := src.Publish(ctx, &rl.ConfigSpec{SkipPaths: []string{"/v1"}}); err != nil {
+		t.Fatal(err)
+	}
+	_, results := watchRedis(t, src)
+	nextReload(t, results)
+
+	mr.HSet("rl:config", "version", "2", "spec", "{not json")
+	for i := 0; i < 3; i++ {
+		if err := client.Publish(ctx, "rl:config:updates", 2).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if r := nextReload(t, results); r.version != 2 || r.err == nil {
+		t.Fatalf("invalid version: %+v, want an error for version 2", r)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if len(results) != 0 {
+		t.Fatalf("%d more results reported for the invalid version, want none", len(results))
+	}
+
+	// Once the next version is published, it is the next result
+	if _, err := src.Publish(ctx, &rl.ConfigSpec{SkipPaths: []string{"/v3"}}); err != nil {
+		t.Fatal(err)
+	}
+	if r := nextReload(t, results); r.version != 3 || r.err != nil {
+		t.Fatalf("reload after the invalid version: %+v, want version 3", r)
+	}
+}
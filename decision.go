@@ -0,0 +1,172 @@
+package rateLimiter
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// DecisionReason identifies why the rate limiter rejected a request, or why it let a
+// request through without enforcing its bucket.
+type DecisionReason string
+
+const (
+	// ReasonRateLimited means the request's bucket had no token left.
+	ReasonRateLimited DecisionReason = "rate-limited"
+
+	// ReasonBlocked means the client IP is blocked after too many failed attempts.
+	ReasonBlocked DecisionReason = "blocked"
+
+	// ReasonFailedAttempts means the client IP has recent failed attempts and has to
+	// wait before trying again, though it is not blocked yet.
+	ReasonFailedAttempts DecisionReason = "failed-attempts"
+
+	// ReasonWebSocketForbidden means the tier's policy doesn't allow WebSocket connections.
+	ReasonWebSocketForbidden DecisionReason = "websocket-forbidden"
+
+	// ReasonStorageUnavailable means the bucket state couldn't be read and the policy's
+	// FailureMode rejected the request.
+	ReasonStorageUnavailable DecisionReason = "storage-unavailable"
+
+	// ReasonBypassToken means the request had a valid bypass token.
+	ReasonBypassToken DecisionReason = "bypass-token"
+
+	// ReasonWhitelisted means the client IP is whitelisted.
+	ReasonWhitelisted DecisionReason = "whitelisted"
+
+	// ReasonSkipPath means the request's path is in SkipPaths.
+	ReasonSkipPath DecisionReason = "skip-path"
+
+	// ReasonDryRun means the request's policy is in dry run and would have rejected it.
+	ReasonDryRun DecisionReason = "dry-run"
+)
+
+// Decision describes a request the rate limiter rejected. It is passed to the
+// OnLimitReached, OnBlocked, OnWebSocketForbidden and OnError handlers.
+type Decision struct {
+	// Reason is why the request was rejected
+	Reason DecisionReason
+
+	// Status is the HTTP status code the default response uses
+	Status int
+
+	// Identifier is the user ID, or the client IP if there is none. It is empty when the
+	// request was rejected before the user was identified, as for blocked IPs.
+	Identifier string
+
+	// Tier is the user's tier, empty when Identifier is
+	Tier string
+
+	// Key is the storage key of the request's bucket, empty if no bucket was checked
+	Key string
+
+	// WebSocket is set for WebSocket upgrade requests
+	WebSocket bool
+
+	// Policy is the policy the request was checked against, including the stricter limits
+	// applied to unauthenticated requests and by FailLocal
+	Policy Policy
+
+	// Limit is the policy's MaxRequests
+	Limit int
+
+	// Remaining is the number of whole tokens left in the bucket
+	Remaining int
+
+	// RetryAfter is how long the client should wait before trying again. The
+	// Retry-After header is already set from it when it is positive.
+	RetryAfter time.Duration
+
+	// FailedAttempts is the number of recent failed attempts of the client IP, set for
+	// ReasonBlocked and ReasonFailedAttempts
+	FailedAttempts int64
+
+	// Err is the storage error, set for ReasonStorageUnavailable
+	Err error
+}
+
+// DecisionHandler writes the response for a rejected request. It may also call
+// c.Next() to let the request through after all, for example to only log rejections.
+type DecisionHandler func(c *fiber.Ctx, d Decision) error
+
+// Message returns a short human-readable description of the decision, the "error"
+// member of the default responses.
+func (d Decision) Message() string {
+	switch d.Reason {
+	case ReasonRateLimited:
+		if d.WebSocket {
+			return "rate limit exceeded for WebSocket connection"
+		}
+		return "rate limit exceeded"
+	case ReasonBlocked:
+		return "IP temporarily blocked due to too many failed attempts"
+	case ReasonFailedAttempts:
+		return "Too many failed attempts, please wait before trying again"
+	case ReasonWebSocketForbidden:
+		return "WebSocket connections not allowed for your tier"
+	case ReasonStorageUnavailable:
+		return "rate limit service unavailable"
+	}
+	return string(d.Reason)
+}
+
+// retryAfterSeconds returns RetryAfter in whole seconds, rounded up.
+func (d Decision) retryAfterSeconds() int {
+	return int(math.Ceil(d.RetryAfter.Seconds()))
+}
+
+// reject records the rejection, sets Retry-After and passes d to handler, or to the
+// default handler for d.Reason if handler is nil.
+func reject(c *fiber.Ctx, cfg RateLimiterConfig, handler DecisionHandler, d Decision) error {
+	recordRequest(c, cfg, OutcomeDenied, d.Tier, d.Reason)
+	publishRequest(c, cfg, EventLimited, Event{
+		Identifier:     d.Identifier,
+		Tier:           d.Tier,
+		Key:            d.Key,
+		Reason:         d.Reason,
+		Policy:         d.Policy,
+		Remaining:      d.Remaining,
+		RetryAfter:     d.RetryAfter,
+		FailedAttempts: d.FailedAttempts,
+		Err:            d.Err,
+	})
+
+	// Add Retry-After header (RFC 7231, Section 7.1.3)
+	if d.RetryAfter > 0 {
+		c.Set("Retry-After", fmt.Sprintf("%d", d.retryAfterSeconds()))
+	}
+
+	if handler == nil {
+		handler = defaultDecisionHandler
+	}
+	return handler(c, d)
+}
+
+// defaultDecisionHandler writes the JSON responses the rate limiter has always sent.
+func defaultDecisionHandler(c *fiber.Ctx, d Decision) error {
+	body := fiber.Map{"error": d.Message()}
+
+	switch d.Reason {
+	case ReasonRateLimited:
+		if !d.WebSocket {
+			body["limit"] = d.Limit
+		}
+		body["retry_after"] = d.retryAfterSeconds()
+		body["tier"] = d.Tier
+	case ReasonBlocked:
+		body["retry_after"] = d.retryAfterSeconds()
+		body["block_remaining"] = d.RetryAfter.String()
+		if d.FailedAttempts > 0 {
+			body["failed_attempts"] = d.FailedAttempts
+		}
+	case ReasonFailedAttempts:
+		body["retry_after"] = d.retryAfterSeconds()
+		body["failed_attempts"] = d.FailedAttempts
+	case ReasonWebSocketForbidden:
+		body["tier"] = d.Tier
+	}
+
+	return c.Status(d.Status).JSON(body)
+}
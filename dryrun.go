@@ -0,0 +1,99 @@
+package rateLimiter
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Values of the X-RateLimit-Would-Limit header, naming the policies that would have
+// rejected a request they weren't enforced on.
+const (
+	wouldLimitDryRun = "dry-run"
+	wouldLimitShadow = "shadow"
+)
+
+// DryRunRecorder is implemented by a MetricsRecorder that also counts the requests
+// policies in dry-run or shadow mode would have rejected.
+type DryRunRecorder interface {
+	// WouldLimit is called when a policy that isn't enforced would have rejected a
+	// request. shadow reports whether it was a Policy.Shadow rather than a policy
+	// with DryRun set.
+	WouldLimit(tier string, shadow bool)
+}
+
+// shadowKey returns the key of the bucket a shadow policy keeps for the request whose
+// bucket is at key, separate from the enforced policy's bucket.
+func shadowKey(key string) string {
+	return key + ":shadow"
+}
+
+// checkPolicies appends the result of the shadow policy of policy, if it has one, to
+// result, the request's check against policy itself. The shadow policy is evaluated on
+// its own bucket and never affects the request, so its storage errors are only logged
+// and leave it out.
+func checkPolicies(ctx context.Context, store bucketStore, key string, policy Policy,
+	result bucketResult) []bucketResult {
+	results := []bucketResult{result}
+	if policy.Shadow == nil {
+		return results
+	}
+
+	shadow, err := checkTokenBucket(ctx, store, shadowKey(key), *policy.Shadow)
+	if err != nil {
+		store.log.log(ctx, LogStorageError, "Rate limit shadow policy could not be checked",
+			slog.String("key", key), slog.Any("error", err))
+		return results
+	}
+	shadow.shadow = true
+	return append(results, shadow)
+}
+
+// wouldLimitPolicies returns the policies that would have rejected a request without
+// being enforced: the checked policy if it is in dry run and rejected the request, and
+// its shadow policy if that did.
+func wouldLimitPolicies(results []bucketResult) []string {
+	var wouldLimit []string
+	for _, result := range results {
+		switch {
+		case result.allowed:
+		case result.shadow:
+			wouldLimit = append(wouldLimit, wouldLimitShadow)
+		case result.policy.DryRun:
+			wouldLimit = append(wouldLimit, wouldLimitDryRun)
+		}
+	}
+	return wouldLimit
+}
+
+// recordWouldLimit logs and counts the policies in wouldLimit, and adds the
+// X-RateLimit-Would-Limit header unless cfg.HeaderMode is HeadersNone.
+func recordWouldLimit(c *fiber.Ctx, cfg RateLimiterConfig, key, tier string, wouldLimit []string) {
+	for _, name := range wouldLimit {
+		cfg.log().log(c.UserContext(), LogWouldLimit, "Rate limit policy would have rejected request",
+			slog.String("key", key), slog.String("tier", tier), slog.String("policy", name))
+		if recorder, ok := cfg.Metrics.(DryRunRecorder); ok {
+			recorder.WouldLimit(metricTier(cfg, tier), name == wouldLimitShadow)
+		}
+	}
+
+	if cfg.HeaderMode.orDefault() != HeadersNone {
+		c.Set("X-RateLimit-Would-Limit", strings.Join(wouldLimit, ", "))
+	}
+}
+
+// unauthenticatedPolicy returns the stricter version of policy applied to unauthenticated
+// requests: half the refill rate and half the burst capacity, for the shadow policy too.
+// The burst capacity is kept at 1 or more, since a bucket that can't hold a token
+// rejects every request.
+func unauthenticatedPolicy(policy Policy) Policy {
+	policy.TokensPerSecond = policy.TokensPerSecond * 0.5
+	policy.BurstCapacity = max(1, policy.BurstCapacity/2)
+	if policy.Shadow != nil {
+		shadow := unauthenticatedPolicy(*policy.Shadow)
+		policy.Shadow = &shadow
+	}
+	return policy
+}
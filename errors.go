@@ -0,0 +1,101 @@
+package rateLimiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Errors returned by Storage implementations. They are wrapped together with the
+// underlying error, so callers should test for them with errors.Is.
+var (
+	// ErrStorageUnavailable means the backend couldn't be reached or failed to
+	// execute the operation. The bucket may be fine; another storage can be tried.
+	ErrStorageUnavailable = errors.New("rate limit storage unavailable")
+
+	// ErrCorruptState means the bucket was read but its contents couldn't be decoded.
+	// Retrying won't help; the bucket should be reset.
+	ErrCorruptState = errors.New("rate limit bucket state corrupt")
+
+	// ErrTimeout means the operation didn't complete before its deadline.
+	// Every *TimeoutError matches it.
+	ErrTimeout = errors.New("rate limit storage timeout")
+)
+
+// TimeoutError is returned by storage operations that didn't complete before
+// their deadline, either the per-operation StorageTimeout or the request's own.
+type TimeoutError struct {
+	// Op is the storage operation that timed out, e.g. "GetBucket"
+	Op string
+
+	// Key is the bucket key the operation was working on
+	Key string
+
+	// Err is the underlying error
+	Err error
+}
+
+func (e *TimeoutError) Error() string {
+	return "rate limit storage " + e.Op + " " + e.Key + " timed out: " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// Timeout reports true, so TimeoutError satisfies net.Error style checks.
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+// Is reports whether target is ErrTimeout.
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
+// WrapTimeout returns a *TimeoutError if err was caused by a deadline, and err otherwise.
+// It and the other Wrap functions are for Storage implementations, to return errors
+// the rate limiter can classify.
+func WrapTimeout(op, key string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return &TimeoutError{Op: op, Key: key, Err: err}
+	}
+	return err
+}
+
+// WrapUnavailable classifies a backend error: deadlines become a *TimeoutError,
+// cancellations are returned as is, and anything else wraps ErrStorageUnavailable.
+func WrapUnavailable(op, key string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	err = WrapTimeout(op, key, err)
+	if errors.Is(err, ErrTimeout) || errors.Is(err, context.Canceled) || errors.Is(err, ErrStorageUnavailable) {
+		return err
+	}
+	return fmt.Errorf("%w: %s %s: %w", ErrStorageUnavailable, op, key, err)
+}
+
+// WrapCorrupt wraps a decoding error for the bucket at key with ErrCorruptState.
+func WrapCorrupt(key string, err error) error {
+	return fmt.Errorf("%w: %s: %w", ErrCorruptState, key, err)
+}
+
+// storageContext returns the context for a single storage operation.
+// If timeout is positive the context gets its own deadline, so every operation
+// is bounded even when the request context has none.
+func storageContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
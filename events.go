@@ -0,0 +1,278 @@
+package rateLimiter
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// EventType identifies something that happened in the rate limiter.
+type EventType string
+
+const (
+	// EventAllowed is published for every request checked and let through, including
+	// requests let through by a dry-run policy or by FailOpen.
+	EventAllowed EventType = "allowed"
+
+	// EventLimited is published for every request rejected, with the rejection's Reason.
+	// Requests rejected because their IP is blocked are included.
+	EventLimited EventType = "limited"
+
+	// EventBlocked is published when an IP is blocked after too many failed attempts.
+	// The block ends after the event's RetryAfter; EventUnblocked is not published then.
+	EventBlocked EventType = "blocked"
+
+	// EventUnblocked is published only when an IP block is lifted by hand with
+	// Limiter.UnblockIP. Blocks are Redis keys that simply expire, so a block running
+	// out is never reported: subscribers tracking blocked IPs must drop each one once
+	// the RetryAfter of its EventBlocked has passed.
+	EventUnblocked EventType = "unblocked"
+
+	// EventBypassed is published for every request that skipped rate limiting because of
+	// a bypass token or a whitelisted IP.
+	EventBypassed EventType = "bypassed"
+
+	// EventStorageFallback is published when a bucket is checked on the fallback storage
+	// because the primary storage failed.
+	EventStorageFallback EventType = "storage_fallback"
+)
+
+// Event describes something that happened in the rate limiter. Fields that don't apply
+// to an event's Type are left at their zero value.
+type Event struct {
+	// Type is what happened
+	Type EventType
+
+	// Time is when it happened, according to RateLimiterConfig.Clock
+	Time time.Time
+
+	// IP is the client IP of the request, or the IP blocked or unblocked
+	IP string
+
+	// Identifier is the user ID, or the client IP if there is none. It is empty for
+	// requests handled before the user was identified.
+	Identifier string
+
+	// Tier is the user's tier, empty when Identifier is
+	Tier string
+
+	// Route is the RoutePolicy path the request matched, or else the route path as
+	// registered with Fiber, which under app.Use is the middleware's own
+	Route string
+
+	// Key is the storage key of the bucket checked
+	Key string
+
+	// Reason is why the request was rejected (EventLimited), bypassed (EventBypassed) or
+	// let through despite its bucket (EventAllowed)
+	Reason DecisionReason
+
+	// Policy is the policy the request was checked against
+	Policy Policy
+
+	// Remaining is the number of whole tokens left in the bucket
+	Remaining int
+
+	// RetryAfter is how long the client has to wait, for EventLimited, or how long the
+	// IP is blocked for, for EventBlocked
+	RetryAfter time.Duration
+
+	// FailedAttempts is the number of recent failed attempts of the IP
+	FailedAttempts int64
+
+	// Backend is the name of the primary storage that failed, for EventStorageFallback
+	// (see StorageName)
+	Backend string
+
+	// Err is the storage error that caused the event, if any
+	Err error
+}
+
+// EventHook is called synchronously for each event it is registered for, on the
+// goroutine handling the request. It must be safe for concurrent use and return
+// quickly; slow work belongs in a Subscription. It must not register or remove hooks,
+// or close subscriptions, of the bus it is called by.
+type EventHook func(e Event)
+
+// EventBus delivers the rate limiter's events to hooks and subscriptions. Set it as
+// RateLimiterConfig.Events; one bus can be shared by several Limiters. Create it with
+// NewEventBus.
+type EventBus struct {
+	mu    sync.RWMutex
+	hooks []*eventHook
+	subs  []*Subscription
+}
+
+// eventHook is a hook with the event types it is registered for, all if none.
+type eventHook struct {
+	fn    EventHook
+	types []EventType
+}
+
+// NewEventBus creates an EventBus without hooks or subscriptions.
+func NewEventBus() *EventBus {
+	return &EventBus{}
+}
+
+// wants reports whether an event of type t is delivered to a hook or subscription
+// registered for types.
+func wants(types []EventType, t EventType) bool {
+	return len(types) == 0 || slices.Contains(types, t)
+}
+
+// On registers hook for events of the given types, or every event if none are given.
+// It returns a function that unregisters the hook.
+func (b *EventBus) On(hook EventHook, types ...EventType) (remove func()) {
+	h := &eventHook{fn: hook, types: types}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.hooks = append(b.hooks, h)
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		b.hooks = slices.DeleteFunc(b.hooks, func(other *eventHook) bool { return other == h })
+	}
+}
+
+// Subscribe returns a Subscription receiving events of the given types, or every event
+// if none are given, on a channel buffered for buffer events (at least 1). Publishing
+// never waits for a subscriber: events that don't fit in the buffer are dropped and
+// counted by Subscription.Dropped.
+func (b *EventBus) Subscribe(buffer int, types ...EventType) *Subscription {
+	s := &Subscription{
+		bus:    b,
+		events: make(chan Event, max(1, buffer)),
+		types:  types,
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.subs = append(b.subs, s)
+	return s
+}
+
+// Publish delivers e to the hooks registered for its type, in registration order, and
+// then to the subscriptions. It is called by the rate limiter and can also be used to
+// inject events, for example in tests.
+func (b *EventBus) Publish(e Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, h := range b.hooks {
+		if wants(h.types, e.Type) {
+			h.fn(e)
+		}
+	}
+	for _, s := range b.subs {
+		if wants(s.types, e.Type) {
+			s.send(e)
+		}
+	}
+}
+
+// Subscription receives events from an EventBus on a buffered channel.
+type Subscription struct {
+	bus     *EventBus
+	events  chan Event
+	types   []EventType
+	dropped atomic.Uint64
+}
+
+// Events returns the channel events are delivered on. It is closed by Close.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Dropped returns the number of events dropped because the channel's buffer was full.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close stops the delivery of events and closes the channel. Events already buffered
+// can still be received. Close can be called more than once.
+func (s *Subscription) Close() {
+	b := s.bus
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// The bus lock is held by Publish while sending, so the channel is never closed
+	// under a sender
+	if i := slices.Index(b.subs, s); i >= 0 {
+		b.subs = slices.Delete(b.subs, i, i+1)
+		close(s.events)
+	}
+}
+
+// send delivers e unless the buffer is full.
+func (s *Subscription) send(e Event) {
+	select {
+	case s.events <- e:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// publishEvent publishes e to cfg.Events, if it is set, timestamped with cfg.Clock.
+func publishEvent(cfg RateLimiterConfig, e Event) {
+	if cfg.Events == nil {
+		return
+	}
+	e.Time = clockOrSystem(cfg.Clock).Now()
+	cfg.Events.Publish(e)
+}
+
+// publishRequest publishes an event of type t for the request c.
+func publishRequest(c *fiber.Ctx, cfg RateLimiterConfig, t EventType, e Event) {
+	if cfg.Events == nil {
+		return
+	}
+	route, _ := matchRoute(c, cfg)
+	e.Type, e.IP, e.Route = t, strings.Clone(c.IP()), route
+	publishEvent(cfg, e)
+}
+
+// publishAllowed publishes EventAllowed for a request let through by its bucket check.
+func publishAllowed(c *fiber.Ctx, cfg RateLimiterConfig, identifier, tier, key string, result bucketResult) {
+	e := Event{
+		Identifier: identifier,
+		Tier:       tier,
+		Key:        key,
+		Reason:     allowedReason(result),
+		Policy:     result.policy,
+	}
+	if !result.unknown {
+		e.Remaining = result.remaining()
+	}
+	publishRequest(c, cfg, EventAllowed, e)
+}
+
+// UnblockIP lifts the block on ip and forgets its failed attempts, and publishes
+// EventUnblocked, which is not published for blocks that expire. Blocks are kept in
+// Redis only, so it does nothing without Redis.
+func (l *Limiter) UnblockIP(ctx context.Context, ip string) error {
+	cfg := l.Config()
+	client := redisClient(cfg)
+	if client == nil {
+		return nil
+	}
+
+	blockKey, failedKey := securityKeys(cfg.KeyPrefix, ip)
+	ctx, cancel := storageContext(ctx, cfg.StorageTimeout)
+	defer cancel()
+
+	// Both keys share the IP's hash tag, so this is cluster-safe
+	if err := client.Del(ctx, blockKey, failedKey).Err(); err != nil {
+		return WrapUnavailable("UnblockIP", blockKey, err)
+	}
+	publishEvent(cfg, Event{Type: EventUnblocked, IP: ip})
+	return nil
+}
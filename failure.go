@@ -0,0 +1,92 @@
+package rateLimiter
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+)
+
+// FailureMode controls how a request is decided when its bucket state can't be read
+// from either the primary or the fallback storage.
+type FailureMode string
+
+const (
+	// FailClosed rejects the request with 503 Service Unavailable.
+	// This is the default when a policy doesn't set a FailureMode.
+	FailClosed FailureMode = "fail-closed"
+
+	// FailOpen lets the request through without rate limiting.
+	FailOpen FailureMode = "fail-open"
+
+	// FailLocal applies a conservative, local-only limit (half the burst capacity and
+	// half the refill rate of the policy) kept in the instance's in-memory storage.
+	// If that also fails, the request is rejected as with FailClosed.
+	FailLocal FailureMode = "fail-local"
+)
+
+// errStorageFailed is returned by applyFailureMode when the request must be rejected.
+var errStorageFailed = errors.New("rate limit storage unavailable")
+
+// valid reports whether m is a known failure mode or empty.
+func (m FailureMode) valid() bool {
+	switch m {
+	case "", FailClosed, FailOpen, FailLocal:
+		return true
+	}
+	return false
+}
+
+// orDefault returns FailClosed if m is empty.
+func (m FailureMode) orDefault() FailureMode {
+	if m == "" {
+		return FailClosed
+	}
+	return m
+}
+
+// applyFailureMode decides a request whose bucket check failed with cause, according to
+// policy.FailureMode. It returns errStorageFailed when the request must be rejected,
+// or cause itself if the request was cancelled.
+func applyFailureMode(fallbackStorage Storage, cfg RateLimiterConfig, key, tier string,
+	policy Policy, cause error) (bucketResult, error) {
+
+	// The client is gone, there is nobody left to decide for
+	if errors.Is(cause, context.Canceled) {
+		return bucketResult{}, cause
+	}
+
+	mode := policy.FailureMode.orDefault()
+
+	// Log and record which mode was used
+	msg := "Rate limit storage unavailable, applying failure mode"
+	if errors.Is(cause, ErrTimeout) {
+		msg = "Rate limit storage timed out, applying failure mode"
+	}
+	cfg.log().log(context.Background(), LogFailureMode, msg, slog.String("key", key),
+		slog.String("tier", tier), slog.String("mode", string(mode)), slog.Any("error", cause))
+	if cfg.Metrics != nil {
+		cfg.Metrics.StorageFailure(metricTier(cfg, tier), mode)
+	}
+
+	switch mode {
+	case FailOpen:
+		return bucketResult{allowed: true, unknown: true, policy: policy, fallback: true}, nil
+	case FailLocal:
+		local := policy
+		local.BurstCapacity = max(1, policy.BurstCapacity/2)
+		local.TokensPerSecond = policy.TokensPerSecond * 0.5
+
+		// The fallback storage is always the instance's in-memory store. The request
+		// context may be what failed, so the local check doesn't use it.
+		store := bucketStore{primary: fallbackStorage, fallback: fallbackStorage, clock: cfg.Clock,
+			metrics: cfg.Metrics, log: cfg.log()}
+		result, err := checkTokenBucket(context.Background(), store, key+":local", local)
+		if err != nil {
+			return bucketResult{}, errStorageFailed
+		}
+		result.fallback = true
+		return result, nil
+	default:
+		return bucketResult{}, errStorageFailed
+	}
+}
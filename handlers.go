@@ -1,8 +1,9 @@
 package rateLimiter
 
 import (
-	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"strings"
 	"time"
 
@@ -10,71 +11,92 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
-// checkSecurity performs security-related checks before rate limiting
-func checkSecurity(c *fiber.Ctx, cfg RateLimiterConfig) (bool, error) {
+// checkSecurity performs security-related checks before rate limiting.
+// It returns the reason the request bypasses rate limiting (ReasonBypassToken or
+// ReasonWhitelisted), empty if it doesn't, or the Decision rejecting it if the client
+// IP is blocked.
+func checkSecurity(c *fiber.Ctx, cfg RateLimiterConfig, store bucketStore) (DecisionReason, *Decision, error) {
 	// Check for bypass token
 	if bypassToken := c.Get("X-RateLimit-Bypass"); bypassToken != "" {
 		if cfg.GlobalSecurity.ValidateBypassToken(bypassToken) {
-			return true, nil
+			return ReasonBypassToken, nil, nil
 		}
 	}
 
 	// Check IP whitelist
 	ip := c.IP()
 	if cfg.GlobalSecurity.IsIPWhitelisted(ip) {
-		return true, nil
+		return ReasonWhitelisted, nil, nil
 	}
 
-	// Check if IP is blocked due to too many failed attempts
-	if isBlocked, err := checkIPBlocked(c, cfg); err != nil {
-		return false, err
-	} else if isBlocked {
-		return false, c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
-			"error": "IP temporarily blocked due to too many failed attempts",
-		})
+	// Check if IP is blocked due to too many failed attempts.
+	// Blocks are kept in Redis only; while it is unavailable the regular
+	// rate limits still apply, so the check is skipped rather than failing the request.
+	block, err := checkIPBlocked(c, cfg, store)
+	if err != nil {
+		if errors.Is(err, ErrStorageUnavailable) || errors.Is(err, ErrTimeout) {
+			cfg.log().log(c.UserContext(), LogBlockCheckSkipped, "Skipping IP block check, storage unavailable",
+				slog.String("ip", ip), slog.Any("error", err))
+			return "", nil, nil
+		}
+		return "", nil, err
 	}
+	return "", block, nil
+}
 
-	return false, nil
+// securityKeys returns the Redis keys holding the block status and the failed attempt
+// count of ip. The IP is used as a hash tag so both keys map to the same Redis Cluster
+// slot and can be read in a single pipeline.
+func securityKeys(prefix, ip string) (blockKey, failedKey string) {
+	return fmt.Sprintf("%s:blocked:{%s}", prefix, ip), fmt.Sprintf("%s:failed:{%s}", prefix, ip)
 }
 
-// checkIPBlocked checks if an IP is blocked due to too many failed attempts
-func checkIPBlocked(c *fiber.Ctx, cfg RateLimiterConfig) (bool, error) {
+// failedAttemptsTTL is how long failed attempts are remembered after the last one.
+const failedAttemptsTTL = 24 * time.Hour
+
+// checkIPBlocked checks if an IP is blocked due to too many failed attempts, or has to
+// wait after a recent failed attempt. It returns the Decision rejecting the request,
+// or nil if the request may go on. Redis is called through the store's circuit
+// breaker, so the check fails fast while the circuit is open.
+func checkIPBlocked(c *fiber.Ctx, cfg RateLimiterConfig, store bucketStore) (*Decision, error) {
 	ip := c.IP()
-	blockKey := fmt.Sprintf("%s:blocked:%s", cfg.KeyPrefix, ip)
-	failedKey := fmt.Sprintf("%s:failed:%s", cfg.KeyPrefix, ip)
+	blockKey, failedKey := securityKeys(cfg.KeyPrefix, ip)
 
-	if cfg.Redis != nil {
-		ctx := context.Background()
-		pipe := cfg.Redis.Pipeline()
+	if client := redisClient(cfg); client != nil {
+		ctx, cancel := storageContext(c.UserContext(), cfg.StorageTimeout)
+		defer cancel()
 
-		// Get both block status and failed attempts
-		pipe.Get(ctx, blockKey)
-		pipe.Get(ctx, failedKey)
+		pipe := client.Pipeline()
 
-		results, err := pipe.Exec(ctx)
-		if err != nil && err != redis.Nil {
-			return false, err
-		}
+		// Get both block status and failed attempts, with the time left on each
+		blockedCmd := pipe.Get(ctx, blockKey)
+		blockTTLCmd := pipe.TTL(ctx, blockKey)
+		failedCmd := pipe.Get(ctx, failedKey)
+		failedTTLCmd := pipe.TTL(ctx, failedKey)
 
-		// Check if IP is blocked
-		blocked, _ := results[0].(*redis.StringCmd).Bool()
-		if blocked {
-			// Get remaining block time
-			ttl, err := cfg.Redis.TTL(ctx, blockKey).Result()
-			if err != nil {
-				return true, nil
+		err := store.guardRedis(func() error {
+			if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+				return WrapUnavailable("checkIPBlocked", blockKey, err)
 			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
 
-			// Return block status with remaining time
-			return true, c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
-				"error":           "IP temporarily blocked due to too many failed attempts",
-				"retry_after":     int(ttl.Seconds()),
-				"block_remaining": ttl.String(),
-			})
+		failedAttempts, _ := failedCmd.Int64()
+
+		// Check if IP is blocked
+		if blocked, _ := blockedCmd.Bool(); blocked {
+			return &Decision{
+				Reason:         ReasonBlocked,
+				Status:         fiber.StatusTooManyRequests,
+				RetryAfter:     max(0, blockTTLCmd.Val()),
+				FailedAttempts: failedAttempts,
+			}, nil
 		}
 
 		// Check if we should apply progressive blocking
-		failedAttempts, _ := results[1].(*redis.StringCmd).Int64()
 		if failedAttempts > 0 {
 			// Apply temporary slowdown for IPs with failed attempts
 			// but not yet blocked
@@ -83,40 +105,52 @@ func checkIPBlocked(c *fiber.Ctx, cfg RateLimiterConfig) (bool, error) {
 				slowdownDuration = 30 * time.Second
 			}
 
-			return false, c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
-				"error":           "Too many failed attempts, please wait before trying again",
-				"retry_after":     int(slowdownDuration.Seconds()),
-				"failed_attempts": failedAttempts,
-			})
+			// The failed attempts expiry is refreshed on every attempt, so the time
+			// since the last one is how much of it has run out
+			sinceLast := failedAttemptsTTL - failedTTLCmd.Val()
+			if failedTTLCmd.Val() > 0 && sinceLast < slowdownDuration {
+				return &Decision{
+					Reason:         ReasonFailedAttempts,
+					Status:         fiber.StatusTooManyRequests,
+					RetryAfter:     slowdownDuration - sinceLast,
+					FailedAttempts: failedAttempts,
+				}, nil
+			}
 		}
 	}
 
-	return false, nil
+	return nil, nil
 }
 
-// recordFailedAttempt records a failed attempt and blocks the IP if necessary
-func recordFailedAttempt(c *fiber.Ctx, cfg RateLimiterConfig) error {
+// recordFailedAttempt records a failed attempt and blocks the IP if necessary.
+// Like checkIPBlocked, it calls Redis through the store's circuit breaker.
+func recordFailedAttempt(c *fiber.Ctx, cfg RateLimiterConfig, store bucketStore) error {
 	ip := c.IP()
-	failedKey := fmt.Sprintf("%s:failed:%s", cfg.KeyPrefix, ip)
-	blockKey := fmt.Sprintf("%s:blocked:%s", cfg.KeyPrefix, ip)
+	blockKey, failedKey := securityKeys(cfg.KeyPrefix, ip)
 
-	if cfg.Redis != nil {
-		ctx := context.Background()
-		pipe := cfg.Redis.Pipeline()
+	if client := redisClient(cfg); client != nil {
+		ctx, cancel := storageContext(c.UserContext(), cfg.StorageTimeout)
+		defer cancel()
 
-		// Increment failed attempts
-		pipe.Incr(ctx, failedKey)
-		pipe.Expire(ctx, failedKey, 24*time.Hour)
+		// Increment failed attempts and refresh their expiry atomically.
+		// Both commands use the same key, so the transaction is cluster-safe.
+		pipe := client.TxPipeline()
+		incr := pipe.Incr(ctx, failedKey)
+		pipe.Expire(ctx, failedKey, failedAttemptsTTL)
 
-		// Check if we should block the IP
-		pipe.Get(ctx, failedKey)
-
-		results, err := pipe.Exec(ctx)
+		err := store.guardRedis(func() error {
+			_, err := pipe.Exec(ctx)
+			return WrapUnavailable("recordFailedAttempt", failedKey, err)
+		})
 		if err != nil {
 			return err
 		}
+		if recorder, ok := cfg.Metrics.(SecurityRecorder); ok {
+			recorder.FailedAttempt()
+		}
 
-		failedAttempts := results[1].(*redis.IntCmd).Val()
+		// Check if we should block the IP
+		failedAttempts := incr.Val()
 		if failedAttempts >= int64(cfg.GlobalSecurity.MaxFailedAttempts) {
 			// Calculate progressive block duration
 			// Each additional failed attempt increases block time
@@ -130,27 +164,113 @@ func recordFailedAttempt(c *fiber.Ctx, cfg RateLimiterConfig) error {
 			}
 
 			// Block the IP with progressive duration
-			cfg.Redis.Set(ctx, blockKey, true, blockDuration)
+			err := store.guardRedis(func() error {
+				return WrapUnavailable("recordFailedAttempt", blockKey, client.Set(ctx, blockKey, true, blockDuration).Err())
+			})
+			if err != nil {
+				return err
+			}
+			if recorder, ok := cfg.Metrics.(SecurityRecorder); ok {
+				recorder.IPBlocked(blockDuration)
+			}
 
-			// Log the blocking event
-			fmt.Printf("IP %s blocked for %v due to %d failed attempts\n",
-				ip, blockDuration, failedAttempts)
+			cfg.log().log(ctx, LogIPBlocked, "IP blocked after too many failed attempts",
+				slog.String("ip", ip), slog.Duration("duration", blockDuration),
+				slog.Int64("failed_attempts", failedAttempts))
+			publishRequest(c, cfg, EventBlocked, Event{RetryAfter: blockDuration, FailedAttempts: failedAttempts})
 		}
 	}
 
 	return nil
 }
 
+// matchRoute returns the route the request is accounted to, and whether it has a
+// RoutePolicy. Route policies are matched against the registered route first and then
+// against the request path, since under app.Use the current route is the middleware's
+// own. Without a route policy the request is accounted to the registered route.
+// The request path is copied, as Fiber reuses its memory once the request is done and
+// the route may be kept, for example as a metric label.
+func matchRoute(c *fiber.Ctx, cfg RateLimiterConfig) (string, bool) {
+	if _, ok := cfg.RoutePolicy[c.Route().Path]; ok {
+		return c.Route().Path, true
+	}
+	if _, ok := cfg.RoutePolicy[c.Path()]; ok {
+		return strings.Clone(c.Path()), true
+	}
+	return c.Route().Path, false
+}
+
+// resolvePolicy returns the policy that applies to the request, where it was
+// configured ("route <path>", "tier <tier>" or "default") and the route the request
+// is accounted to (see matchRoute).
+// A route policy takes precedence over the tier policy, and DefaultPolicy
+// is used when neither is configured.
+func resolvePolicy(c *fiber.Ctx, cfg RateLimiterConfig, tier string) (Policy, string, string) {
+	route, ok := matchRoute(c, cfg)
+	if ok {
+		return cfg.RoutePolicy[route], "route " + route, route
+	}
+	if policy, ok := cfg.TierPolicy[tier]; ok {
+		return policy, "tier " + tier, route
+	}
+	return cfg.DefaultPolicy, "default", route
+}
+
+// bucketKey returns the storage key of the bucket of identifier on route. Requests
+// with a route policy get a bucket of their own even under app.Use, where every other
+// request shares the middleware's route.
+func bucketKey(prefix, identifier, route string) string {
+	endpoint := strings.ReplaceAll(strings.Trim(route, "/"), "/", "_")
+	return fmt.Sprintf("%s:%s:%s", prefix, identifier, endpoint)
+}
+
+// isAuthEndpoint reports whether a request to route, or to the request path, is an
+// authentication attempt whose rejection counts as a failed attempt.
+func isAuthEndpoint(c *fiber.Ctx, route string) bool {
+	for _, p := range []string{route, c.Path()} {
+		if strings.Contains(p, "auth") || strings.Contains(p, "login") {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleWebSocketUpgrade applies the WebSocket rate limiting rules to an upgrade request
+// using the given storages.
 func HandleWebSocketUpgrade(c *fiber.Ctx, primaryStorage, fallbackStorage Storage, cfg RateLimiterConfig) error {
+	store := bucketStore{
+		primary:  primaryStorage,
+		fallback: fallbackStorage,
+		timeout:  cfg.StorageTimeout,
+		clock:    cfg.Clock,
+		metrics:  cfg.Metrics,
+		tracer:   cfg.Tracer,
+		log:      cfg.log(),
+		events:   cfg.Events,
+	}
+	return handleWebSocketUpgrade(c, store.forRequest(c, cfg), cfg)
+}
+
+func handleWebSocketUpgrade(c *fiber.Ctx, store bucketStore, cfg RateLimiterConfig) error {
+	// Storage calls are bound to the request, so they are abandoned along with it
+	ctx := startCheckSpan(c, cfg, true)
+
 	// Check security first
-	if bypass, err := checkSecurity(c, cfg); err != nil {
+	bypass, block, err := checkSecurity(c, cfg, store)
+	switch {
+	case err != nil:
+		failCheckSpan(c, err)
 		return err
-	} else if bypass {
+	case block != nil:
+		block.WebSocket = true
+		return reject(c, cfg, cfg.OnBlocked, *block)
+	case bypass != "":
+		recordRequest(c, cfg, OutcomeBypassed, "", bypass)
+		publishRequest(c, cfg, EventBypassed, Event{Reason: bypass})
+		auditBypass(c, cfg, bypass)
 		return c.Next()
 	}
 
-	ctx := context.Background()
-
 	// Identify user and tier
 	identifier := cfg.GetUserID(c)
 	if identifier == "" {
@@ -162,54 +282,119 @@ func HandleWebSocketUpgrade(c *fiber.Ctx, primaryStorage, fallbackStorage Storag
 		tier = "free"
 	}
 
-	// Get policy for this tier
-	policy, ok := cfg.TierPolicy[tier]
-	if !ok {
-		policy = cfg.DefaultPolicy
-	}
+	// Get policy for this route and tier
+	policy, policyName, route := resolvePolicy(c, cfg, tier)
+	annotatePolicy(c, policyName)
 
-	// Check if WebSockets are allowed for this tier
+	// Special key for WebSocket connections (usually more expensive)
+	key := bucketKey(cfg.KeyPrefix, identifier, route) + ":ws"
+
+	// Check if WebSockets are allowed for this tier. A policy in dry run only reports
+	// the connections it would forbid
+	if !policy.WebSocketAllowed && policy.DryRun {
+		recordWouldLimit(c, cfg, key, tier, []string{wouldLimitDryRun})
+		recordRequest(c, cfg, OutcomeAllowed, tier, ReasonDryRun)
+		publishRequest(c, cfg, EventAllowed, Event{
+			Identifier: identifier,
+			Tier:       tier,
+			Key:        key,
+			Reason:     ReasonDryRun,
+			Policy:     policy,
+		})
+		return c.Next()
+	}
 	if !policy.WebSocketAllowed {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"error": "WebSocket connections not allowed for your tier",
-			"tier":  tier,
+		return reject(c, cfg, cfg.OnWebSocketForbidden, Decision{
+			Reason:     ReasonWebSocketForbidden,
+			Status:     fiber.StatusForbidden,
+			Identifier: identifier,
+			Tier:       tier,
+			WebSocket:  true,
+			Policy:     policy,
+			Limit:      policy.MaxRequests,
 		})
 	}
 
-	// Special key for WebSocket connections (usually more expensive)
-	endpoint := strings.ReplaceAll(strings.Trim(c.Route().Path, "/"), "/", "_")
-	key := fmt.Sprintf("%s:%s:%s:ws", cfg.KeyPrefix, identifier, endpoint)
-
 	// Use token bucket algorithm for WebSocket rate limiting
-	allow, retryAfter, err := checkTokenBucket(ctx, primaryStorage, fallbackStorage, key, policy)
+	result, err := checkTokenBucket(ctx, store, key, policy)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "internal rate limit error",
-		})
+		result, err = applyFailureMode(store.fallback, cfg, key, tier, policy, err)
+		if err != nil && policy.DryRun {
+			// A policy in dry run never rejects requests, not even when it can't be checked
+			cfg.log().log(ctx, LogFailureMode, "Rate limit dry-run policy could not be checked, allowing request",
+				slog.String("key", key), slog.String("tier", tier), slog.Any("error", err))
+			result, err = bucketResult{allowed: true, unknown: true, policy: policy}, nil
+		}
+		if err != nil {
+			return reject(c, cfg, cfg.OnError, Decision{
+				Reason:     ReasonStorageUnavailable,
+				Status:     fiber.StatusServiceUnavailable,
+				Identifier: identifier,
+				Tier:       tier,
+				Key:        key,
+				WebSocket:  true,
+				Policy:     policy,
+				Limit:      policy.MaxRequests,
+				Err:        err,
+			})
+		}
 	}
 
-	if !allow {
-		c.Set("Retry-After", fmt.Sprintf("%d", retryAfter))
-		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
-			"error":       "rate limit exceeded for WebSocket connection",
-			"retry_after": retryAfter,
-			"tier":        tier,
-		})
+	// Check the shadow policy, if any, next to the enforced one
+	results := checkPolicies(ctx, store, key, policy, result)
+
+	// Set rate limit headers, which are sent with the upgrade response as well
+	setRateLimitHeaders(c, cfg.HeaderMode, store.now(), results...)
+	annotateResult(c, result)
+
+	// Policies that aren't enforced only report what they would have done
+	if wouldLimit := wouldLimitPolicies(results); len(wouldLimit) > 0 {
+		recordWouldLimit(c, cfg, key, tier, wouldLimit)
+	}
+
+	if !result.allowed && !policy.DryRun {
+		return reject(c, cfg, cfg.OnLimitReached, limitedDecision(identifier, tier, key, true, result))
 	}
 
+	recordRequest(c, cfg, OutcomeAllowed, tier, allowedReason(result))
+	publishAllowed(c, cfg, identifier, tier, key, result)
 	return c.Next()
 }
 
+// HandleHTTPRequest applies the rate limiting rules to an HTTP request using the given storages.
 func HandleHTTPRequest(c *fiber.Ctx, primaryStorage, fallbackStorage Storage, cfg RateLimiterConfig) error {
+	store := bucketStore{
+		primary:  primaryStorage,
+		fallback: fallbackStorage,
+		timeout:  cfg.StorageTimeout,
+		clock:    cfg.Clock,
+		metrics:  cfg.Metrics,
+		tracer:   cfg.Tracer,
+		log:      cfg.log(),
+		events:   cfg.Events,
+	}
+	return handleHTTPRequest(c, store.forRequest(c, cfg), cfg)
+}
+
+func handleHTTPRequest(c *fiber.Ctx, store bucketStore, cfg RateLimiterConfig) error {
+	// Storage calls are bound to the request, so they are abandoned along with it
+	ctx := startCheckSpan(c, cfg, false)
+
 	// Check security first
-	if bypass, err := checkSecurity(c, cfg); err != nil {
+	bypass, block, err := checkSecurity(c, cfg, store)
+	switch {
+	case err != nil:
+		failCheckSpan(c, err)
 		return err
-	} else if bypass {
+	case block != nil:
+		return reject(c, cfg, cfg.OnBlocked, *block)
+	case bypass != "":
+		recordRequest(c, cfg, OutcomeBypassed, "", bypass)
+		publishRequest(c, cfg, EventBypassed, Event{Reason: bypass})
+		auditBypass(c, cfg, bypass)
 		return c.Next()
 	}
 
-	ctx := context.Background()
-
 	// Identify user and tier
 	identifier := cfg.GetUserID(c)
 	if identifier == "" {
@@ -221,55 +406,98 @@ func HandleHTTPRequest(c *fiber.Ctx, primaryStorage, fallbackStorage Storage, cf
 		tier = "free"
 	}
 
-	// Get policy for this tier
-	policy, ok := cfg.TierPolicy[tier]
-	if !ok {
-		policy = cfg.DefaultPolicy
-	}
+	// Get policy for this route and tier
+	policy, policyName, route := resolvePolicy(c, cfg, tier)
+	annotatePolicy(c, policyName)
 
 	// Check authentication requirement
 	if policy.Security.RequireAuthentication && identifier == c.IP() {
 		// Apply stricter rate limiting for unauthenticated requests
-		policy.TokensPerSecond = policy.TokensPerSecond * 0.5
-		policy.BurstCapacity = policy.BurstCapacity / 2
+		policy = unauthenticatedPolicy(policy)
 	}
 
 	// Create unique key based on the endpoint access
-	endpoint := strings.ReplaceAll(strings.Trim(c.Route().Path, "/"), "/", "_")
-	key := fmt.Sprintf("%s:%s:%s", cfg.KeyPrefix, identifier, endpoint)
+	key := bucketKey(cfg.KeyPrefix, identifier, route)
 
 	// Use token bucket algorithm
-	allow, retryAfter, err := checkTokenBucket(ctx, primaryStorage, fallbackStorage, key, policy)
+	result, err := checkTokenBucket(ctx, store, key, policy)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "internal rate limit error",
-		})
+		result, err = applyFailureMode(store.fallback, cfg, key, tier, policy, err)
+		if err != nil && policy.DryRun {
+			// A policy in dry run never rejects requests, not even when it can't be checked
+			cfg.log().log(ctx, LogFailureMode, "Rate limit dry-run policy could not be checked, allowing request",
+				slog.String("key", key), slog.String("tier", tier), slog.Any("error", err))
+			result, err = bucketResult{allowed: true, unknown: true, policy: policy}, nil
+		}
+		if err != nil {
+			return reject(c, cfg, cfg.OnError, Decision{
+				Reason:     ReasonStorageUnavailable,
+				Status:     fiber.StatusServiceUnavailable,
+				Identifier: identifier,
+				Tier:       tier,
+				Key:        key,
+				WebSocket:  false,
+				Policy:     policy,
+				Limit:      policy.MaxRequests,
+				Err:        err,
+			})
+		}
 	}
 
+	// Check the shadow policy, if any, next to the enforced one
+	results := checkPolicies(ctx, store, key, policy, result)
+
 	// Set rate limit headers
-	c.Set("X-RateLimit-Limit", fmt.Sprintf("%d", policy.MaxRequests))
-	c.Set("X-RateLimit-Remaining", fmt.Sprintf("%d", int(policy.BurstCapacity)))
-	c.Set("X-RateLimit-Reset", fmt.Sprintf("%d", int(time.Now().Add(time.Second*time.Duration(retryAfter)).Unix())))
+	setRateLimitHeaders(c, cfg.HeaderMode, store.now(), results...)
+	annotateResult(c, result)
 
-	if !allow {
+	// Policies that aren't enforced only report what they would have done
+	if wouldLimit := wouldLimitPolicies(results); len(wouldLimit) > 0 {
+		recordWouldLimit(c, cfg, key, tier, wouldLimit)
+	}
+
+	if !result.allowed && !policy.DryRun {
 		// Record failed attempt if this is an authentication endpoint
-		if strings.Contains(endpoint, "auth") || strings.Contains(endpoint, "login") {
-			if err := recordFailedAttempt(c, cfg); err != nil {
+		if isAuthEndpoint(c, route) {
+			if err := recordFailedAttempt(c, cfg, store); err != nil {
 				// Log error but continue with rate limit response
-				fmt.Printf("Error recording failed attempt: %v\n", err)
+				cfg.log().log(ctx, LogStorageError, "Failed attempt could not be recorded",
+					slog.String("ip", c.IP()), slog.Any("error", err))
 			}
 		}
 
-		// Add Retry-After header (RFC 7231, Section 7.1.3)
-		c.Set("Retry-After", fmt.Sprintf("%d", retryAfter))
-
-		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
-			"error":       "rate limit exceeded",
-			"limit":       policy.MaxRequests,
-			"retry_after": retryAfter,
-			"tier":        tier,
-		})
+		return reject(c, cfg, cfg.OnLimitReached, limitedDecision(identifier, tier, key, false, result))
 	}
 
+	recordRequest(c, cfg, OutcomeAllowed, tier, allowedReason(result))
+	publishAllowed(c, cfg, identifier, tier, key, result)
 	return c.Next()
 }
+
+// allowedReason returns why a request was let through despite its bucket, empty if
+// the bucket allowed it.
+func allowedReason(result bucketResult) DecisionReason {
+	switch {
+	case result.unknown:
+		return ReasonStorageUnavailable
+	case !result.allowed:
+		return ReasonDryRun
+	}
+	return ""
+}
+
+// limitedDecision describes a request rejected because its bucket had no token left.
+func limitedDecision(identifier, tier, key string, websocket bool, result bucketResult) Decision {
+	return Decision{
+		Reason:     ReasonRateLimited,
+		Status:     fiber.StatusTooManyRequests,
+		Identifier: identifier,
+		Tier:       tier,
+		Key:        key,
+		WebSocket:  websocket,
+		Policy:     result.policy,
+		Limit:      result.policy.MaxRequests,
+		Remaining:  result.remaining(),
+		RetryAfter: time.Duration(result.retryAfterSeconds()) * time.Second,
+	}
+}
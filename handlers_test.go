@@ -0,0 +1,187 @@
+package rateLimiter_test
+
+import (
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	rl "github.com/Popoola-Opeyemi/rateLimiter"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// newUseApp returns an app with the limiter of cfg installed with app.Use, answering
+// every path with 200.
+func newUseApp(t *testing.T, cfg rl.RateLimiterConfig) *fiber.App {
+	t.Helper()
+
+	limiter, err := rl.NewLimiter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { limiter.Close() })
+
+	app := fiber.New()
+	app.Use(limiter.Handler())
+	app.All("/*", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+	return app
+}
+
+// get requests path from app and returns the response status and remaining header.
+func get(t *testing.T, app *fiber.App, path string) (int, string) {
+	t.Helper()
+
+	resp, err := app.Test(httptest.NewRequest("GET", path, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, resp.Header.Get("X-RateLimit-Remaining")
+}
+
+func baseConfig() rl.RateLimiterConfig {
+	return rl.RateLimiterConfig{
+		DefaultPolicy: rl.Policy{MaxRequests: 100, BurstCapacity: 100, TokensPerSecond: 1},
+		KeyPrefix:     "rl",
+		GetUserID:     func(c *fiber.Ctx) string { return "" },
+		GetUserTier:   func(c *fiber.Ctx) string { return "" },
+	}
+}
+
+func TestRoutePolicyHasOwnBucketUnderUse(t *testing.T) {
+	cfg := baseConfig()
+	cfg.RoutePolicy = map[string]rl.Policy{
+		"/expensive": {MaxRequests: 2, BurstCapacity: 2, TokensPerSecond: 0.01},
+	}
+	app := newUseApp(t, cfg)
+
+	for i := 0; i < 2; i++ {
+		if status, _ := get(t, app, "/expensive"); status != fiber.StatusOK {
+			t.Fatalf("request %d to /expensive: status %d, want 200", i+1, status)
+		}
+	}
+	if status, _ := get(t, app, "/expensive"); status != fiber.StatusTooManyRequests {
+		t.Fatalf("third request to /expensive: status %d, want 429", status)
+	}
+
+	status, remaining := get(t, app, "/cheap")
+	if status != fiber.StatusOK || remaining != "99" {
+		t.Fatalf("/cheap: status %d, remaining %q; want 200 and 99", status, remaining)
+	}
+}
+
+func TestFailedAttemptsRecordedUnderUse(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := baseConfig()
+	cfg.Redis = redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	cfg.DefaultPolicy = rl.Policy{MaxRequests: 1, BurstCapacity: 1, TokensPerSecond: 0.01}
+	cfg.GlobalSecurity = rl.SecurityConfig{MaxFailedAttempts: 3, BlockDuration: time.Minute}
+	app := newUseApp(t, cfg)
+
+	get(t, app, "/api/login")
+	if status, _ := get(t, app, "/api/login"); status != fiber.StatusTooManyRequests {
+		t.Fatalf("second login: status %d, want 429", status)
+	}
+
+	failed, err := mr.Get("rl:failed:{0.0.0.0}")
+	if err != nil || failed != "1" {
+		t.Fatalf("failed attempts = %q (%v), want 1", failed, err)
+	}
+}
+
+func TestWhitelistedRangeBypassesLimits(t *testing.T) {
+	cfg := baseConfig()
+	cfg.DefaultPolicy = rl.Policy{MaxRequests: 1, BurstCapacity: 1, TokensPerSecond: 0.01}
+	cfg.GlobalSecurity.WhitelistIPs = []string{"0.0.0.0/8"}
+	app := newUseApp(t, cfg)
+
+	for i := 0; i < 3; i++ {
+		if status, _ := get(t, app, "/"); status != fiber.StatusOK {
+			t.Fatalf("request %d: status %d, want 200", i+1, status)
+		}
+	}
+}
+
+func TestUnauthenticatedPolicyKeepsOneToken(t *testing.T) {
+	cfg := baseConfig()
+	cfg.DefaultPolicy = rl.Policy{
+		MaxRequests: 1, BurstCapacity: 1, TokensPerSecond: 0.01,
+		Security: rl.SecurityConfig{RequireAuthentication: true},
+	}
+	app := newUseApp(t, cfg)
+
+	if status, _ := get(t, app, "/"); status != fiber.StatusOK {
+		t.Fatalf("first unauthenticated request: status %d, want 200", status)
+	}
+	if status, _ := get(t, app, "/"); status != fiber.StatusTooManyRequests {
+		t.Fatalf("second unauthenticated request: status %d, want 429", status)
+	}
+}
+
+func TestEventRouteUsesRoutePolicyUnderUse(t *testing.T) {
+	cfg := baseConfig()
+	cfg.RoutePolicy = map[string]rl.Policy{
+		"/expensive": {MaxRequests: 1, BurstCapacity: 1, TokensPerSecond: 0.01},
+	}
+	cfg.Events = rl.NewEventBus()
+	var routes []string
+	cfg.Events.On(func(e rl.Event) { routes = append(routes, string(e.Type)+" "+e.Route) },
+		rl.EventAllowed, rl.EventLimited)
+	app := newUseApp(t, cfg)
+
+	get(t, app, "/expensive")
+	get(t, app, "/expensive")
+	get(t, app, "/cheap")
+
+	want := []string{"allowed /expensive", "limited /expensive", "allowed /"}
+	if !slices.Equal(routes, want) {
+		t.Fatalf("event routes: got %q, want %q", routes, want)
+	}
+}
+
+func TestDryRunPolicyAllowsForbiddenWebSocket(t *testing.T) {
+	cfg := baseConfig()
+	cfg.DefaultPolicy.DryRun = true
+	cfg.Events = rl.NewEventBus()
+	var reasons []rl.DecisionReason
+	cfg.Events.On(func(e rl.Event) { reasons = append(reasons, e.Reason) }, rl.EventAllowed, rl.EventLimited)
+	app := newUseApp(t, cfg)
+
+	req := httptest.NewRequest("GET", "/ws", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("upgrade forbidden by a dry-run policy: status %d, want 200", resp.StatusCode)
+	}
+	if got := resp.Header.Get("X-RateLimit-Would-Limit"); got != "dry-run" {
+		t.Errorf("X-RateLimit-Would-Limit = %q, want dry-run", got)
+	}
+	if !slices.Equal(reasons, []rl.DecisionReason{rl.ReasonDryRun}) {
+		t.Errorf("event reasons: got %q, want [%s]", reasons, rl.ReasonDryRun)
+	}
+}
+
+func TestFallbackEventHasRequestIPAndRoute(t *testing.T) {
+	cfg := baseConfig()
+	storage := &flakyStorage{InMemoryStorage: rl.NewInMemoryStorageWithConfig(rl.InMemoryConfig{JanitorInterval: -1})}
+	storage.fail.Store(true)
+	cfg.Storage = storage
+	cfg.RoutePolicy = map[string]rl.Policy{
+		"/expensive": {MaxRequests: 10, BurstCapacity: 10, TokensPerSecond: 1},
+	}
+	cfg.Events = rl.NewEventBus()
+	var events []rl.Event
+	cfg.Events.On(func(e rl.Event) { events = append(events, e) }, rl.EventStorageFallback)
+	app := newUseApp(t, cfg)
+
+	get(t, app, "/expensive")
+
+	if len(events) != 1 || events[0].IP != "0.0.0.0" || events[0].Route != "/expensive" {
+		t.Fatalf("fallback events: %+v, want one with IP 0.0.0.0 and route /expensive", events)
+	}
+}
@@ -0,0 +1,152 @@
+package rateLimiter
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HeaderMode selects the rate limit headers added to responses.
+type HeaderMode string
+
+const (
+	// HeadersLegacy sends X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset.
+	// This is the default when RateLimiterConfig doesn't set a HeaderMode.
+	HeadersLegacy HeaderMode = "legacy"
+
+	// HeadersIETF sends the RateLimit and RateLimit-Policy headers of the IETF draft
+	// "RateLimit header fields for HTTP", e.g.
+	//
+	//	RateLimit: limit=100, remaining=50, reset=30
+	//	RateLimit-Policy: 100;w=60;burst=50
+	//
+	// A policy's shadow policy is listed in RateLimit-Policy too, marked with a shadow
+	// parameter, e.g. "100;w=60;burst=50, 50;w=60;burst=25;shadow".
+	HeadersIETF HeaderMode = "ietf"
+
+	// HeadersBoth sends both the legacy and the IETF headers.
+	HeadersBoth HeaderMode = "both"
+
+	// HeadersNone sends no rate limit headers. Rejected requests still get Retry-After.
+	HeadersNone HeaderMode = "none"
+)
+
+// valid reports whether m is a known header mode or empty.
+func (m HeaderMode) valid() bool {
+	switch m {
+	case "", HeadersLegacy, HeadersIETF, HeadersBoth, HeadersNone:
+		return true
+	}
+	return false
+}
+
+// orDefault returns HeadersLegacy if m is empty.
+func (m HeaderMode) orDefault() HeaderMode {
+	if m == "" {
+		return HeadersLegacy
+	}
+	return m
+}
+
+// setRateLimitHeaders reports the state of the buckets checked for a request, one result
+// per policy that applied to it, in the headers selected by mode.
+//
+// The legacy headers and the IETF RateLimit header describe the enforced policy closest
+// to its limit; RateLimit-Policy lists every policy, including shadow policies. Bucket
+// state is left out for results decided without reading the bucket, as with FailOpen.
+func setRateLimitHeaders(c *fiber.Ctx, mode HeaderMode, now time.Time, results ...bucketResult) {
+	if len(results) == 0 {
+		return
+	}
+	closest := closestToLimit(results)
+
+	switch mode.orDefault() {
+	case HeadersLegacy:
+		setLegacyHeaders(c, now, closest)
+	case HeadersIETF:
+		setIETFHeaders(c, closest, results)
+	case HeadersBoth:
+		setLegacyHeaders(c, now, closest)
+		setIETFHeaders(c, closest, results)
+	}
+}
+
+// setLegacyHeaders sets the X-RateLimit-* headers:
+//   - X-RateLimit-Limit: the policy's MaxRequests
+//   - X-RateLimit-Remaining: the whole tokens left in the bucket
+//   - X-RateLimit-Reset: the Unix time, rounded up to the second, at which the bucket is full again
+func setLegacyHeaders(c *fiber.Ctx, now time.Time, result bucketResult) {
+	c.Set("X-RateLimit-Limit", fmt.Sprintf("%d", result.policy.MaxRequests))
+	if result.unknown {
+		return
+	}
+
+	reset := now.Add(result.resetAfter)
+	resetUnix := reset.Unix()
+	if reset.Nanosecond() > 0 {
+		resetUnix++
+	}
+
+	c.Set("X-RateLimit-Remaining", fmt.Sprintf("%d", result.remaining()))
+	c.Set("X-RateLimit-Reset", fmt.Sprintf("%d", resetUnix))
+}
+
+// setIETFHeaders sets the RateLimit header for closest and the RateLimit-Policy header
+// for every policy in results.
+func setIETFHeaders(c *fiber.Ctx, closest bucketResult, results []bucketResult) {
+	policies := make([]string, 0, len(results))
+	seen := make(map[string]bool, len(results))
+	for _, result := range results {
+		quota, window := policyQuota(result.policy)
+		item := fmt.Sprintf("%d;w=%d;burst=%d", quota, window, result.policy.BurstCapacity)
+		if result.shadow {
+			item += ";shadow"
+		}
+		if !seen[item] {
+			seen[item] = true
+			policies = append(policies, item)
+		}
+	}
+	c.Set("RateLimit-Policy", strings.Join(policies, ", "))
+
+	if closest.unknown {
+		return
+	}
+	quota, _ := policyQuota(closest.policy)
+	reset := int(math.Ceil(closest.resetAfter.Seconds()))
+	c.Set("RateLimit", fmt.Sprintf("limit=%d, remaining=%d, reset=%d", quota, closest.remaining(), reset))
+}
+
+// closestToLimit returns the enforced result with the fewest remaining tokens, preferring
+// the one that takes longest to refill on a tie. Results with unknown state are only
+// returned if there is nothing else. Shadow results never are, since they don't limit
+// the request; the first result is always an enforced one.
+func closestToLimit(results []bucketResult) bucketResult {
+	closest := results[0]
+	for _, result := range results[1:] {
+		switch {
+		case result.shadow, result.unknown:
+			continue
+		case closest.unknown,
+			result.remaining() < closest.remaining(),
+			result.remaining() == closest.remaining() && result.resetAfter > closest.resetAfter:
+			closest = result
+		}
+	}
+	return closest
+}
+
+// policyQuota expresses a token bucket policy as a quota per time window for the IETF
+// headers: MaxRequests (or BurstCapacity if it isn't set) per the whole number of seconds
+// it takes to refill that many tokens.
+func policyQuota(policy Policy) (quota, window int) {
+	quota = policy.MaxRequests
+	if quota <= 0 {
+		quota = policy.BurstCapacity
+	}
+	window = max(1, int(math.Ceil(float64(quota)/policy.TokensPerSecond)))
+	return quota, window
+}
@@ -0,0 +1,66 @@
+package rateLimiter_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	rl "github.com/Popoola-Opeyemi/rateLimiter"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestIETFHeadersListShadowPolicy(t *testing.T) {
+	cfg := baseConfig()
+	cfg.HeaderMode = rl.HeadersIETF
+	cfg.RoutePolicy = map[string]rl.Policy{
+		"/api/search": {
+			MaxRequests: 60, BurstCapacity: 10, TokensPerSecond: 1,
+			Shadow: &rl.Policy{MaxRequests: 30, BurstCapacity: 2, TokensPerSecond: 0.5},
+		},
+	}
+	app := newUseApp(t, cfg)
+
+	for i, want := range []struct{ ratelimit, wouldLimit string }{
+		{"limit=60, remaining=9, reset=1", ""},
+		{"limit=60, remaining=8, reset=2", ""},
+		{"limit=60, remaining=7, reset=3", "shadow"},
+	} {
+		resp, err := app.Test(httptest.NewRequest("GET", "/api/search", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != fiber.StatusOK {
+			t.Fatalf("request %d: status %d, want 200", i+1, resp.StatusCode)
+		}
+		if got := resp.Header.Get("RateLimit-Policy"); got != "60;w=60;burst=10, 30;w=60;burst=2;shadow" {
+			t.Errorf("request %d: RateLimit-Policy = %q", i+1, got)
+		}
+		// RateLimit describes the enforced policy even once the shadow one is exhausted
+		if got := resp.Header.Get("RateLimit"); got != want.ratelimit {
+			t.Errorf("request %d: RateLimit = %q, want %q", i+1, got, want.ratelimit)
+		}
+		if got := resp.Header.Get("X-RateLimit-Would-Limit"); got != want.wouldLimit {
+			t.Errorf("request %d: X-RateLimit-Would-Limit = %q, want %q", i+1, got, want.wouldLimit)
+		}
+	}
+}
+
+func TestIETFHeadersSinglePolicy(t *testing.T) {
+	cfg := baseConfig()
+	cfg.HeaderMode = rl.HeadersBoth
+	app := newUseApp(t, cfg)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for header, want := range map[string]string{
+		"RateLimit-Policy":      "100;w=100;burst=100",
+		"RateLimit":             "limit=100, remaining=99, reset=1",
+		"X-RateLimit-Limit":     "100",
+		"X-RateLimit-Remaining": "99",
+	} {
+		if got := resp.Header.Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package rateLimiter
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LogEvent identifies a kind of log record written by the rate limiter. Every record
+// has an "event" attribute with its LogEvent, and its level and sampling are
+// configured per event in LoggingConfig.
+type LogEvent string
+
+const (
+	// LogStorageError is a failed storage read or write that the rate limiter recovered
+	// from, for example by using the fallback storage. Logged at Warn by default.
+	LogStorageError LogEvent = "storage_error"
+
+	// LogFallback is a bucket check moved to the fallback storage because the primary
+	// storage failed. Logged at Warn by default.
+	LogFallback LogEvent = "fallback"
+
+	// LogFailureMode is a request decided by the policy's FailureMode because no storage
+	// could be read. Logged at Error by default.
+	LogFailureMode LogEvent = "failure_mode"
+
+	// LogBlockCheckSkipped is an IP block check skipped because Redis was unavailable.
+	// Logged at Warn by default.
+	LogBlockCheckSkipped LogEvent = "block_check_skipped"
+
+	// LogIPBlocked is an IP blocked after too many failed attempts. Logged at Warn by default.
+	LogIPBlocked LogEvent = "ip_blocked"
+
+	// LogWouldLimit is a request a dry-run or shadow policy would have rejected.
+	// Logged at Info by default.
+	LogWouldLimit LogEvent = "would_limit"
+
+	// LogConfigReload is a configuration reloaded by one of the watchers.
+	// Logged at Info by default.
+	LogConfigReload LogEvent = "config_reload"
+
+	// LogConfigReloadError is a configuration reload that failed, leaving the active
+	// configuration unchanged. Logged at Error by default.
+	LogConfigReloadError LogEvent = "config_reload_error"
+
+	// LogAuditError is a bypass that couldn't be audited, because the AuditLog's buffer
+	// was full or a sink failed.
+	// Logged at Error by default.
+	LogAuditError LogEvent = "audit_error"
+)
+
+// defaultLogLevels are the levels of the events LoggingConfig.Levels doesn't set.
+var defaultLogLevels = map[LogEvent]slog.Level{
+	LogStorageError:      slog.LevelWarn,
+	LogFallback:          slog.LevelWarn,
+	LogFailureMode:       slog.LevelError,
+	LogBlockCheckSkipped: slog.LevelWarn,
+	LogIPBlocked:         slog.LevelWarn,
+	LogWouldLimit:        slog.LevelInfo,
+	LogConfigReload:      slog.LevelInfo,
+	LogConfigReloadError: slog.LevelError,
+	LogAuditError:        slog.LevelError,
+}
+
+// LoggingConfig defines the level of each kind of log record and how often records
+// of the same kind are written.
+type LoggingConfig struct {
+	// Levels overrides the level of individual events. For example, setting LogWouldLimit
+	// to slog.LevelDebug hides dry run records unless the logger is at debug level.
+	Levels map[LogEvent]slog.Level
+
+	// SampleBurst, if positive, is the number of records of each event written per
+	// SampleInterval; further records in the interval are dropped. The next record
+	// written reports how many were dropped in a "dropped" attribute. This keeps an
+	// outage of the storage from flooding the log with one record per request.
+	SampleBurst int
+
+	// SampleInterval is the length of the sampling interval. Required when SampleBurst is set.
+	SampleInterval time.Duration
+}
+
+// problems returns a description of every invalid field in cfg, prefixed with name.
+func (cfg LoggingConfig) problems(name string) []string {
+	var problems []string
+	events := make([]string, 0, len(cfg.Levels))
+	for event := range cfg.Levels {
+		events = append(events, string(event))
+	}
+	sort.Strings(events)
+	for _, event := range events {
+		if _, ok := defaultLogLevels[LogEvent(event)]; !ok {
+			problems = append(problems, fmt.Sprintf("%s.Levels: %q is not a known LogEvent", name, event))
+		}
+	}
+	if cfg.SampleBurst < 0 {
+		problems = append(problems, fmt.Sprintf("%s.SampleBurst must not be negative, got %d", name, cfg.SampleBurst))
+	}
+	if cfg.SampleInterval < 0 {
+		problems = append(problems, fmt.Sprintf("%s.SampleInterval must not be negative, got %s", name, cfg.SampleInterval))
+	}
+	if cfg.SampleBurst > 0 && cfg.SampleInterval == 0 {
+		problems = append(problems, fmt.Sprintf("%s.SampleInterval must be set when SampleBurst is", name))
+	}
+	return problems
+}
+
+// eventLogger writes the rate limiter's log records at the configured levels,
+// sampling them per event.
+type eventLogger struct {
+	logger   *slog.Logger
+	levels   map[LogEvent]slog.Level
+	burst    int
+	interval time.Duration
+	clock    Clock
+
+	mu      sync.Mutex
+	windows map[LogEvent]*logWindow
+}
+
+// logWindow counts the records of one event in the current sampling interval.
+type logWindow struct {
+	start   time.Time
+	written int
+	dropped int
+}
+
+// newEventLogger creates an eventLogger writing to logger, or slog.Default() if it is nil.
+func newEventLogger(logger *slog.Logger, cfg LoggingConfig, clock Clock) *eventLogger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	levels := make(map[LogEvent]slog.Level, len(defaultLogLevels))
+	for event, level := range defaultLogLevels {
+		levels[event] = level
+	}
+	for event, level := range cfg.Levels {
+		levels[event] = level
+	}
+
+	return &eventLogger{
+		logger:   logger,
+		levels:   levels,
+		burst:    cfg.SampleBurst,
+		interval: cfg.SampleInterval,
+		clock:    clockOrSystem(clock),
+		windows:  make(map[LogEvent]*logWindow),
+	}
+}
+
+// log writes a record of event with msg and attrs, unless the logger doesn't handle
+// the event's level or the record is sampled out. A nil eventLogger writes nothing.
+func (l *eventLogger) log(ctx context.Context, event LogEvent, msg string, attrs ...slog.Attr) {
+	if l == nil {
+		return
+	}
+	level := l.levels[event]
+	if !l.logger.Enabled(ctx, level) {
+		return
+	}
+
+	dropped, ok := l.sample(event)
+	if !ok {
+		return
+	}
+
+	attrs = append(attrs, slog.String("event", string(event)))
+	if dropped > 0 {
+		attrs = append(attrs, slog.Int("dropped", dropped))
+	}
+	l.logger.LogAttrs(ctx, level, msg, attrs...)
+}
+
+// sample reports whether a record of event may be written now, and how many records
+// of it were dropped since the last one written.
+func (l *eventLogger) sample(event LogEvent) (dropped int, ok bool) {
+	if l.burst <= 0 {
+		return 0, true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	w := l.windows[event]
+	if w == nil {
+		w = &logWindow{start: now}
+		l.windows[event] = w
+	}
+	if now.Sub(w.start) >= l.interval {
+		w.start, w.written = now, 0
+	}
+	if w.written >= l.burst {
+		w.dropped++
+		return 0, false
+	}
+
+	w.written++
+	dropped, w.dropped = w.dropped, 0
+	return dropped, true
+}
+
+// log returns the logger for cfg: the one a Limiter attached to it, which keeps the
+// sampling state across requests, or a new one.
+func (cfg RateLimiterConfig) log() *eventLogger {
+	if cfg.logger != nil {
+		return cfg.logger
+	}
+	return newEventLogger(cfg.Logger, cfg.Logging, cfg.Clock)
+}
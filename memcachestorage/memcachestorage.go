@@ -0,0 +1,218 @@
+// Package memcachestorage provides a rateLimiter.Storage backed by memcached, for
+// environments where memcached is available but Redis is not:
+//
+//	client := memcache.New("10.0.0.1:11211", "10.0.0.2:11211")
+//	limiter, err := rateLimiter.NewLimiter(rateLimiter.RateLimiterConfig{
+//		Storage: memcachestorage.New(client, memcachestorage.Config{}),
+//		// ...
+//	})
+//
+// It is a separate package so that applications not using it don't depend on the
+// memcache client.
+package memcachestorage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	rateLimiter "github.com/Popoola-Opeyemi/rateLimiter"
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+var _ rateLimiter.AtomicStorage = (*Storage)(nil)
+
+// memcacheMaxKeyLength is the longest key memcached accepts.
+const memcacheMaxKeyLength = 250
+
+// errCASRetriesExhausted is returned when a bucket keeps changing between read and write.
+var errCASRetriesExhausted = errors.New("too many concurrent updates")
+
+// Config defines how a Storage updates buckets.
+// Zero values are replaced with the defaults noted on each field.
+type Config struct {
+	// MaxRetries is how many times TakeToken retries when another request updated the
+	// bucket between its read and its compare-and-swap. Defaults to 10.
+	MaxRetries int
+
+	// Clock is the source of the current time for bucket updates and expiry.
+	// memcached itself still evicts items by its own clock. Defaults to the system clock.
+	Clock rateLimiter.Clock
+}
+
+// withDefaults returns a copy of cfg with zero values replaced by defaults.
+func (cfg Config) withDefaults() Config {
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = 10
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = rateLimiter.SystemClock()
+	}
+	return cfg
+}
+
+// Storage implements rateLimiter.Storage using memcached as the backend.
+// It provides distributed rate limiting for environments where memcached is available
+// but Redis is not.
+//
+// It implements rateLimiter.AtomicStorage using memcached's compare-and-swap: a bucket is read with
+// its CAS token and only written back if no other request changed it in the meantime,
+// otherwise the check is retried with the new state.
+//
+// The memcache client has no context support, so ctx is checked before each attempt and
+// the client's own Timeout bounds each call.
+type Storage struct {
+	client     *memcache.Client
+	maxRetries int
+	clock      rateLimiter.Clock
+}
+
+// New creates a new memcached-based storage backend.
+// The provided client must be configured with the memcached servers to use.
+func New(client *memcache.Client, cfg Config) *Storage {
+	cfg = cfg.withDefaults()
+	return &Storage{client: client, maxRetries: cfg.MaxRetries, clock: cfg.Clock}
+}
+
+// StorageName implements rateLimiter.NamedStorage.
+func (ms *Storage) StorageName() string {
+	return "memcache"
+}
+
+// GetBucket retrieves the current state of a rate limit bucket from memcached.
+// If the bucket doesn't exist or has expired, it returns default values.
+func (ms *Storage) GetBucket(ctx context.Context, key string) (float64, time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, time.Time{}, rateLimiter.WrapTimeout("GetBucket", key, err)
+	}
+
+	item, err := ms.client.Get(memcacheKey(key))
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return 0, time.Time{}, nil
+	}
+	if err != nil {
+		return 0, time.Time{}, rateLimiter.WrapUnavailable("GetBucket", key, err)
+	}
+
+	tokens, lastUpdate, expiresAt, err := rateLimiter.DecodeBucket(key, item.Value)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	if ms.clock.Now().After(expiresAt) {
+		return 0, time.Time{}, nil
+	}
+	return tokens, lastUpdate, nil
+}
+
+// UpdateBucket updates the state of a rate limit bucket in memcached.
+func (ms *Storage) UpdateBucket(ctx context.Context, key string, tokens float64, expiry time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return rateLimiter.WrapTimeout("UpdateBucket", key, err)
+	}
+
+	if err := ms.client.Set(memcacheItem(key, tokens, ms.clock.Now(), expiry)); err != nil {
+		return rateLimiter.WrapUnavailable("UpdateBucket", key, err)
+	}
+	return nil
+}
+
+// TakeToken refills the bucket at key and takes a token from it, retrying with the
+// latest state whenever a concurrent update wins the compare-and-swap. A corrupt bucket
+// is reset to full capacity.
+func (ms *Storage) TakeToken(ctx context.Context, key string, capacity int, rate float64,
+	expiry time.Duration) (float64, bool, error) {
+	mkey := memcacheKey(key)
+
+	for attempt := 0; attempt <= ms.maxRetries; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return 0, false, rateLimiter.WrapTimeout("TakeToken", key, err)
+		}
+
+		now := ms.clock.Now()
+		item, err := ms.client.Get(mkey)
+		if err != nil && !errors.Is(err, memcache.ErrCacheMiss) {
+			return 0, false, rateLimiter.WrapUnavailable("TakeToken", key, err)
+		}
+
+		tokens := float64(capacity)
+		if item != nil {
+			stored, lastUpdate, expiresAt, err := rateLimiter.DecodeBucket(key, item.Value)
+			if err == nil && !now.After(expiresAt) {
+				tokens = rateLimiter.RefillTokens(stored, lastUpdate, now, capacity, rate)
+			}
+		}
+
+		allowed := tokens >= 1
+		if allowed {
+			tokens--
+		}
+
+		next := memcacheItem(key, tokens, now, expiry)
+		if item == nil {
+			// Add fails if another request created the bucket first
+			err = ms.client.Add(next)
+		} else {
+			next.CasID = item.CasID
+			err = ms.client.CompareAndSwap(next)
+		}
+
+		switch {
+		case err == nil:
+			return tokens, allowed, nil
+		case errors.Is(err, memcache.ErrNotStored), errors.Is(err, memcache.ErrCASConflict),
+			errors.Is(err, memcache.ErrCacheMiss):
+			// Lost the race; try again with the bucket's new state
+			continue
+		default:
+			return 0, false, rateLimiter.WrapUnavailable("TakeToken", key, err)
+		}
+	}
+
+	return 0, false, rateLimiter.WrapUnavailable("TakeToken", key,
+		fmt.Errorf("%w after %d retries", errCASRetriesExhausted, ms.maxRetries))
+}
+
+// memcacheKey maps key to a valid memcached key. Keys that are too long or contain
+// spaces or control characters are replaced by their SHA-256 hash.
+func memcacheKey(key string) string {
+	valid := len(key) <= memcacheMaxKeyLength
+	for i := 0; valid && i < len(key); i++ {
+		valid = key[i] > ' ' && key[i] != 0x7f
+	}
+	if valid {
+		return key
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// memcacheItem builds the item storing a bucket. memcached expires items in whole
+// seconds, so the exact expiry time is also encoded in the value.
+func memcacheItem(key string, tokens float64, now time.Time, expiry time.Duration) *memcache.Item {
+	return &memcache.Item{
+		Key:        memcacheKey(key),
+		Value:      rateLimiter.EncodeBucket(tokens, now, now.Add(expiry)),
+		Expiration: memcacheExpiration(expiry),
+	}
+}
+
+// memcacheExpiration converts expiry to memcached's format: seconds for up to 30 days,
+// an absolute Unix time beyond that. It is rounded up to at least one second.
+// Absolute times are compared against the server's clock, so they use the system clock.
+func memcacheExpiration(expiry time.Duration) int32 {
+	const maxRelative = 30 * 24 * time.Hour
+
+	seconds := int64(math.Ceil(expiry.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	if expiry > maxRelative {
+		return int32(time.Now().Unix() + seconds)
+	}
+	return int32(seconds)
+}
@@ -0,0 +1,240 @@
+package memcachestorage_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	rateLimiter "github.com/Popoola-Opeyemi/rateLimiter"
+	"github.com/Popoola-Opeyemi/rateLimiter/clocktest"
+	"github.com/Popoola-Opeyemi/rateLimiter/memcachestorage"
+	"github.com/Popoola-Opeyemi/rateLimiter/storagetest"
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// fakeMemcached is an in-process memcached server implementing the commands the
+// storage uses: gets, set, add, cas and delete. Items never expire; the storage
+// checks expiry itself from the value.
+type fakeMemcached struct {
+	listener net.Listener
+
+	mu    sync.Mutex
+	items map[string]fakeItem
+	casID uint64
+	keys  []string
+
+	// conflicts is the number of upcoming add and cas commands to fail as if another
+	// client had won the race
+	conflicts int
+}
+
+type fakeItem struct {
+	value []byte
+	cas   uint64
+}
+
+// startFakeMemcached starts a fakeMemcached on a local port, stopped when tb ends.
+func startFakeMemcached(tb testing.TB) *fakeMemcached {
+	tb.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	f := &fakeMemcached{listener: l, items: make(map[string]fakeItem)}
+	go f.serve()
+	tb.Cleanup(func() { l.Close() })
+	return f
+}
+
+// client returns a client connected to the server.
+func (f *fakeMemcached) client() *memcache.Client {
+	client := memcache.New(f.listener.Addr().String())
+	client.MaxIdleConns = 64
+	return client
+}
+
+// failNext makes the next n add and cas commands fail with a conflict.
+func (f *fakeMemcached) failNext(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.conflicts = n
+}
+
+// seenKeys returns every key the server received a command for.
+func (f *fakeMemcached) seenKeys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]string(nil), f.keys...)
+}
+
+func (f *fakeMemcached) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeMemcached) handle(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			fmt.Fprint(w, "ERROR\r\n")
+			w.Flush()
+			continue
+		}
+
+		switch cmd := fields[0]; cmd {
+		case "get", "gets":
+			f.get(w, fields[1:])
+		case "set", "add", "cas":
+			size, err := strconv.Atoi(fields[4])
+			if err != nil {
+				return
+			}
+			value := make([]byte, size+2)
+			if _, err := io.ReadFull(r, value); err != nil {
+				return
+			}
+			var casID string
+			if cmd == "cas" {
+				casID = fields[5]
+			}
+			fmt.Fprintf(w, "%s\r\n", f.store(cmd, fields[1], value[:size], casID))
+		case "delete":
+			fmt.Fprintf(w, "%s\r\n", f.delete(fields[1]))
+		default:
+			fmt.Fprint(w, "ERROR\r\n")
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeMemcached) get(w io.Writer, keys []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, key := range keys {
+		f.keys = append(f.keys, key)
+		if item, ok := f.items[key]; ok {
+			fmt.Fprintf(w, "VALUE %s 0 %d %d\r\n%s\r\n", key, len(item.value), item.cas, item.value)
+		}
+	}
+	fmt.Fprint(w, "END\r\n")
+}
+
+func (f *fakeMemcached) store(cmd, key string, value []byte, casID string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.keys = append(f.keys, key)
+	item, exists := f.items[key]
+	switch {
+	case cmd != "set" && f.conflicts > 0:
+		f.conflicts--
+		if cmd == "add" {
+			return "NOT_STORED"
+		}
+		return "EXISTS"
+	case cmd == "add" && exists:
+		return "NOT_STORED"
+	case cmd == "cas" && !exists:
+		return "NOT_FOUND"
+	case cmd == "cas" && strconv.FormatUint(item.cas, 10) != casID:
+		return "EXISTS"
+	}
+
+	f.casID++
+	f.items[key] = fakeItem{value: append([]byte(nil), value...), cas: f.casID}
+	return "STORED"
+}
+
+func (f *fakeMemcached) delete(key string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.items[key]; !ok {
+		return "NOT_FOUND"
+	}
+	delete(f.items, key)
+	return "DELETED"
+}
+
+func TestStorage(t *testing.T) {
+	var clock *clocktest.Clock
+	storagetest.RunWithOptions(t, func(tb testing.TB) rateLimiter.Storage {
+		clock = clocktest.New(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+		// Concurrent checks on one key conflict often against a single fake server
+		return memcachestorage.New(startFakeMemcached(tb).client(),
+			memcachestorage.Config{MaxRetries: 1000, Clock: clock})
+	}, storagetest.Options{
+		Now:     func() time.Time { return clock.Now() },
+		Advance: func(d time.Duration) { clock.Advance(d) },
+	})
+}
+
+func TestTakeTokenRetriesOnConflict(t *testing.T) {
+	server := startFakeMemcached(t)
+	storage := memcachestorage.New(server.client(), memcachestorage.Config{MaxRetries: 3})
+	ctx := context.Background()
+
+	// The first three attempts lose the race; the last one allowed succeeds
+	server.failNext(3)
+	tokens, allowed, err := storage.TakeToken(ctx, "rl:user1:/", 5, 1, time.Minute)
+	if err != nil || !allowed || tokens != 4 {
+		t.Fatalf("TakeToken = %v, %v, %v; want 4 tokens, allowed", tokens, allowed, err)
+	}
+
+	server.failNext(4)
+	_, _, err = storage.TakeToken(ctx, "rl:user1:/", 5, 1, time.Minute)
+	if !errors.Is(err, rateLimiter.ErrStorageUnavailable) {
+		t.Fatalf("TakeToken after exhausting retries: err = %v, want ErrStorageUnavailable", err)
+	}
+}
+
+func TestInvalidKeysAreHashed(t *testing.T) {
+	server := startFakeMemcached(t)
+	storage := memcachestorage.New(server.client(), memcachestorage.Config{})
+	ctx := context.Background()
+
+	keys := []string{"rl:user 1:/", "rl:" + strings.Repeat("x", 300)}
+	for i, key := range keys {
+		if err := storage.UpdateBucket(ctx, key, float64(i+1), time.Minute); err != nil {
+			t.Fatalf("UpdateBucket(%q): %v", key, err)
+		}
+	}
+	for i, key := range keys {
+		if tokens, _, err := storage.GetBucket(ctx, key); err != nil || tokens != float64(i+1) {
+			t.Fatalf("GetBucket(%q) = %v, %v; want %d tokens", key, tokens, err, i+1)
+		}
+	}
+
+	for _, key := range server.seenKeys() {
+		if len(key) > 250 || strings.ContainsAny(key, " \t\r\n") {
+			t.Fatalf("server received invalid key %q", key)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package rateLimiter
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// MetricsRecorder receives measurements from the rate limiter.
+// Implementations must be safe for concurrent use and should return quickly,
+// as they are called on the request path.
+//
+// The tier passed to a recorder is a key of RateLimiterConfig.TierPolicy, or "default"
+// for requests whose tier has no entry there, so a client can't create new label values
+// by sending made-up tiers.
+//
+// A MetricsRecorder can also implement RequestRecorder, StorageRecorder,
+// SecurityRecorder and DryRunRecorder to receive more measurements.
+// The promcollector package's Collector implements all of them.
+type MetricsRecorder interface {
+	// StorageFailure is called when a bucket couldn't be read from any storage
+	// and the policy's FailureMode was applied.
+	StorageFailure(tier string, mode FailureMode)
+}
+
+// Outcome is how the rate limiter handled a request.
+type Outcome string
+
+const (
+	// OutcomeAllowed means the request was checked and let through.
+	OutcomeAllowed Outcome = "allowed"
+
+	// OutcomeDenied means the request was rejected.
+	OutcomeDenied Outcome = "denied"
+
+	// OutcomeBypassed means the request skipped rate limiting because of a bypass
+	// token or a whitelisted IP.
+	OutcomeBypassed Outcome = "bypassed"
+
+	// OutcomeSkipped means the request's path is in SkipPaths.
+	OutcomeSkipped Outcome = "skipped"
+)
+
+// RequestRecorder is implemented by a MetricsRecorder that counts how requests
+// were handled.
+type RequestRecorder interface {
+	// RequestHandled is called once for every request the rate limiter sees. route is the
+	// RoutePolicy path the request matched, or else the route path as registered with
+	// Fiber, which under app.Use is the middleware's own. tier is empty if the request
+	// was bypassed, skipped or blocked before its user was identified. reason is empty
+	// for requests allowed by their bucket.
+	RequestHandled(outcome Outcome, tier, route string, reason DecisionReason)
+}
+
+// StorageRecorder is implemented by a MetricsRecorder that measures storage calls.
+type StorageRecorder interface {
+	// StorageCall is called after each call to a storage backend with the backend's name
+	// (see StorageName), the operation (GetBucket, UpdateBucket or TakeToken), how long
+	// it took and the error it returned.
+	StorageCall(backend, op string, latency time.Duration, err error)
+
+	// FallbackUsed is called when a bucket is checked on the fallback storage because
+	// the primary storage failed.
+	FallbackUsed()
+}
+
+// SecurityRecorder is implemented by a MetricsRecorder that counts failed attempts
+// and IP blocks.
+type SecurityRecorder interface {
+	// FailedAttempt is called when a rate limited request to an authentication
+	// endpoint is recorded as a failed attempt.
+	FailedAttempt()
+
+	// IPBlocked is called when an IP is blocked for duration after too many failed attempts.
+	IPBlocked(duration time.Duration)
+}
+
+// NamedStorage is implemented by storages that report their own name to StorageName,
+// such as those in the boltstorage and memcachestorage packages.
+type NamedStorage interface {
+	Storage
+
+	// StorageName returns a short name for the type of storage, e.g. "bolt"
+	StorageName() string
+}
+
+// StorageName returns a short name for the type of storage, as reported to a
+// StorageRecorder: "redis", "memory", "sql", the name a NamedStorage reports ("bolt"
+// and "memcache" for the storages in this module) or "custom". A
+// CircuitBreakerStorage is named after the storage it wraps.
+func StorageName(storage Storage) string {
+	switch s := storage.(type) {
+	case *RedisStorage:
+		return "redis"
+	case *InMemoryStorage:
+		return "memory"
+	case *SQLStorage:
+		return "sql"
+	case *CircuitBreakerStorage:
+		return StorageName(s.storage)
+	case *AtomicCircuitBreakerStorage:
+		return StorageName(s.storage)
+	case NamedStorage:
+		return s.StorageName()
+	default:
+		return "custom"
+	}
+}
+
+// defaultTier is the tier reported to recorders and check spans for requests whose tier
+// has no TierPolicy entry and which are therefore checked against DefaultPolicy.
+const defaultTier = "default"
+
+// metricTier returns the value tier is reported as to recorders and check spans: tier
+// itself if cfg.TierPolicy has an entry for it, and defaultTier otherwise. Tiers often
+// come from request headers, and reporting them as is would let any client create new
+// time series. An empty tier, of requests handled before their user was identified,
+// stays empty. tier is cloned, since it may point into a buffer Fiber reuses and
+// recorders keep their label values.
+func metricTier(cfg RateLimiterConfig, tier string) string {
+	if tier == "" {
+		return ""
+	}
+	if _, ok := cfg.TierPolicy[tier]; ok {
+		return strings.Clone(tier)
+	}
+	return defaultTier
+}
+
+// recordRequest reports how a request was handled to cfg.Metrics, if it is a
+// RequestRecorder, and ends the request's rate limit check span.
+func recordRequest(c *fiber.Ctx, cfg RateLimiterConfig, outcome Outcome, tier string, reason DecisionReason) {
+	tier = metricTier(cfg, tier)
+	endCheckSpan(c, outcome, tier, reason)
+	if recorder, ok := cfg.Metrics.(RequestRecorder); ok {
+		route, _ := matchRoute(c, cfg)
+		recorder.RequestHandled(outcome, tier, route, reason)
+	}
+}
+
+// multiRecorder passes measurements on to several recorders, each only those it implements.
+type multiRecorder []MetricsRecorder
+
+// JoinRecorders returns a MetricsRecorder passing measurements on to each of the
+// non-nil recorders, such as a Prometheus collector and OpenTelemetry instruments, or
+// nil if there are none. Each recorder only receives the measurements of the
+// interfaces it implements. Join them once, when building the configuration.
+func JoinRecorders(recorders ...MetricsRecorder) MetricsRecorder {
+	var joined multiRecorder
+	for _, r := range recorders {
+		if r != nil {
+			joined = append(joined, r)
+		}
+	}
+	switch len(joined) {
+	case 0:
+		return nil
+	case 1:
+		return joined[0]
+	}
+	return joined
+}
+
+func (m multiRecorder) StorageFailure(tier string, mode FailureMode) {
+	for _, r := range m {
+		r.StorageFailure(tier, mode)
+	}
+}
+
+func (m multiRecorder) RequestHandled(outcome Outcome, tier, route string, reason DecisionReason) {
+	for _, r := range m {
+		if r, ok := r.(RequestRecorder); ok {
+			r.RequestHandled(outcome, tier, route, reason)
+		}
+	}
+}
+
+func (m multiRecorder) StorageCall(backend, op string, latency time.Duration, err error) {
+	for _, r := range m {
+		if r, ok := r.(StorageRecorder); ok {
+			r.StorageCall(backend, op, latency, err)
+		}
+	}
+}
+
+func (m multiRecorder) FallbackUsed() {
+	for _, r := range m {
+		if r, ok := r.(StorageRecorder); ok {
+			r.FallbackUsed()
+		}
+	}
+}
+
+func (m multiRecorder) WouldLimit(tier string, shadow bool) {
+	for _, r := range m {
+		if r, ok := r.(DryRunRecorder); ok {
+			r.WouldLimit(tier, shadow)
+		}
+	}
+}
+
+func (m multiRecorder) FailedAttempt() {
+	for _, r := range m {
+		if r, ok := r.(SecurityRecorder); ok {
+			r.FailedAttempt()
+		}
+	}
+}
+
+func (m multiRecorder) IPBlocked(duration time.Duration) {
+	for _, r := range m {
+		if r, ok := r.(SecurityRecorder); ok {
+			r.IPBlocked(duration)
+		}
+	}
+}
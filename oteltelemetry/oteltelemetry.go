@@ -0,0 +1,302 @@
+// Package oteltelemetry traces the rate limiter and records its measurements with
+// OpenTelemetry. A Telemetry is both the rateLimiter.Tracer and a MetricsRecorder, and
+// watches the Limiter for its storage gauges:
+//
+//	telemetry, err := oteltelemetry.New(oteltelemetry.Config{})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer telemetry.Close()
+//
+//	cfg.Tracer = telemetry
+//	cfg.Metrics = telemetry
+//	limiter, err := rateLimiter.NewLimiter(cfg)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	telemetry.Watch(limiter)
+//
+// It is a separate package so that applications not using it don't depend on
+// OpenTelemetry.
+package oteltelemetry
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	rateLimiter "github.com/Popoola-Opeyemi/rateLimiter"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var _ interface {
+	rateLimiter.Tracer
+	rateLimiter.RequestRecorder
+	rateLimiter.StorageRecorder
+	rateLimiter.DryRunRecorder
+	rateLimiter.SecurityRecorder
+} = (*Telemetry)(nil)
+
+// instrumentationName is the OpenTelemetry instrumentation scope of the rate limiter.
+const instrumentationName = "github.com/Popoola-Opeyemi/rateLimiter"
+
+// Policy attribute values of the would_limit counter.
+const (
+	policyDryRun = "dry-run"
+	policyShadow = "shadow"
+)
+
+// Config defines where a Telemetry sends traces and metrics.
+// Zero values are replaced with the defaults noted on each field.
+type Config struct {
+	// TracerProvider creates the tracer for the rate limit check and storage spans.
+	// Defaults to the global provider (otel.GetTracerProvider).
+	TracerProvider trace.TracerProvider
+
+	// MeterProvider creates the meter for the rate limiter's instruments.
+	// Defaults to the global provider (otel.GetMeterProvider).
+	MeterProvider metric.MeterProvider
+}
+
+// withDefaults returns a copy of cfg with zero values replaced by defaults.
+func (cfg Config) withDefaults() Config {
+	if cfg.TracerProvider == nil {
+		cfg.TracerProvider = otel.GetTracerProvider()
+	}
+	if cfg.MeterProvider == nil {
+		cfg.MeterProvider = otel.GetMeterProvider()
+	}
+	return cfg
+}
+
+// Telemetry traces rate limit checks and storage calls, and records the rate limiter's
+// measurements as OpenTelemetry instruments. It implements rateLimiter.Tracer,
+// rateLimiter.MetricsRecorder and every optional recorder interface.
+//
+// Its instruments mirror the promcollector metrics: ratelimit.requests,
+// ratelimit.storage.duration, ratelimit.storage.fallbacks, ratelimit.storage.failures,
+// ratelimit.would_limit, ratelimit.failed_attempts and ratelimit.ip_blocks, and for
+// the Limiter passed to Watch the ratelimit.memory.entries, ratelimit.memory.size,
+// ratelimit.reconcile.pending and ratelimit.circuit.state gauges.
+type Telemetry struct {
+	tracer trace.Tracer
+
+	requests        metric.Int64Counter
+	storageDuration metric.Float64Histogram
+	fallbacks       metric.Int64Counter
+	failures        metric.Int64Counter
+	wouldLimit      metric.Int64Counter
+	failedAttempts  metric.Int64Counter
+	blocks          metric.Int64Counter
+
+	// registration is the callback observing the gauges, unregistered by Close
+	registration metric.Registration
+
+	mu      sync.Mutex
+	limiter *rateLimiter.Limiter
+}
+
+// New creates the tracer and instruments of cfg. It returns an error if an instrument
+// or the callback observing the gauges can't be registered with the meter.
+func New(cfg Config) (*Telemetry, error) {
+	cfg = cfg.withDefaults()
+	meter := cfg.MeterProvider.Meter(instrumentationName)
+
+	var errs []error
+	counter := func(name, description string) metric.Int64Counter {
+		instrument, err := meter.Int64Counter(name, metric.WithDescription(description))
+		errs = append(errs, err)
+		return instrument
+	}
+	gauge := func(name, description string, opts ...metric.Int64ObservableGaugeOption) metric.Int64ObservableGauge {
+		instrument, err := meter.Int64ObservableGauge(name, append(opts, metric.WithDescription(description))...)
+		errs = append(errs, err)
+		return instrument
+	}
+
+	t := &Telemetry{
+		tracer: cfg.TracerProvider.Tracer(instrumentationName),
+
+		requests: counter("ratelimit.requests", "Requests seen by the rate limiter, by outcome."),
+		fallbacks: counter("ratelimit.storage.fallbacks",
+			"Bucket checks moved to the fallback storage because the primary storage failed."),
+		failures: counter("ratelimit.storage.failures",
+			"Requests decided by the policy's failure mode because no storage could be read."),
+		wouldLimit: counter("ratelimit.would_limit", "Requests dry-run and shadow policies would have rejected."),
+		failedAttempts: counter("ratelimit.failed_attempts",
+			"Rate limited requests to authentication endpoints recorded as failed attempts."),
+		blocks: counter("ratelimit.ip_blocks", "IPs blocked after too many failed attempts."),
+	}
+	var err error
+	t.storageDuration, err = meter.Float64Histogram("ratelimit.storage.duration",
+		metric.WithDescription("Latency of rate limit storage calls."), metric.WithUnit("s"))
+	errs = append(errs, err)
+
+	entries := gauge("ratelimit.memory.entries", "Buckets held in the in-memory storage.")
+	bytes := gauge("ratelimit.memory.size", "Approximate memory used by the in-memory storage.", metric.WithUnit("By"))
+	pending := gauge("ratelimit.reconcile.pending", "Buckets waiting to be replayed to the primary storage.")
+	circuit := gauge("ratelimit.circuit.state",
+		"State of the primary storage's circuit breaker: 0 closed, 1 open, 2 half-open.")
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	t.registration, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		t.mu.Lock()
+		l := t.limiter
+		t.mu.Unlock()
+		if l == nil {
+			return nil
+		}
+
+		memory := l.MemoryStats()
+		o.ObserveInt64(entries, int64(memory.Entries))
+		o.ObserveInt64(bytes, memory.ApproxBytes)
+		if l.Reconciling() {
+			o.ObserveInt64(pending, int64(l.ReconcilerStats().Pending))
+		}
+		if state, ok := l.CircuitState(); ok {
+			o.ObserveInt64(circuit, int64(state))
+		}
+		return nil
+	}, entries, bytes, pending, circuit)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Watch observes the in-memory storage, reconciler and circuit breaker state of l.
+// Only the Limiter passed last is observed.
+func (t *Telemetry) Watch(l *rateLimiter.Limiter) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.limiter = l
+}
+
+// Close unregisters the callback observing the gauges from the meter. Spans and the
+// other instruments keep being recorded.
+func (t *Telemetry) Close() error {
+	return t.registration.Unregister()
+}
+
+// StartCheck implements rateLimiter.Tracer.
+func (t *Telemetry) StartCheck(ctx context.Context, route string,
+	websocket bool) (context.Context, rateLimiter.CheckSpan) {
+	ctx, span := t.tracer.Start(ctx, "ratelimit.check", trace.WithAttributes(
+		attribute.String("http.route", route),
+		attribute.Bool("ratelimit.websocket", websocket),
+	))
+	return ctx, checkSpan{span}
+}
+
+// StartStorageCall implements rateLimiter.Tracer.
+func (t *Telemetry) StartStorageCall(ctx context.Context, backend, op string) (context.Context, func(error)) {
+	ctx, span := t.tracer.Start(ctx, "ratelimit.storage."+op, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("ratelimit.backend", backend)))
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+// RequestHandled implements rateLimiter.RequestRecorder.
+func (t *Telemetry) RequestHandled(outcome rateLimiter.Outcome, tier, route string, reason rateLimiter.DecisionReason) {
+	t.requests.Add(context.Background(), 1, metric.WithAttributes(
+		attribute.String("ratelimit.decision", string(outcome)),
+		attribute.String("ratelimit.tier", tier),
+		attribute.String("http.route", route),
+		attribute.String("ratelimit.reason", string(reason)),
+	))
+}
+
+// StorageCall implements rateLimiter.StorageRecorder.
+func (t *Telemetry) StorageCall(backend, op string, latency time.Duration, err error) {
+	t.storageDuration.Record(context.Background(), latency.Seconds(), metric.WithAttributes(
+		attribute.String("ratelimit.backend", backend),
+		attribute.String("ratelimit.operation", op),
+		attribute.Bool("error", err != nil),
+	))
+}
+
+// FallbackUsed implements rateLimiter.StorageRecorder.
+func (t *Telemetry) FallbackUsed() {
+	t.fallbacks.Add(context.Background(), 1)
+}
+
+// StorageFailure implements rateLimiter.MetricsRecorder.
+func (t *Telemetry) StorageFailure(tier string, mode rateLimiter.FailureMode) {
+	t.failures.Add(context.Background(), 1, metric.WithAttributes(
+		attribute.String("ratelimit.tier", tier),
+		attribute.String("ratelimit.failure_mode", string(mode)),
+	))
+}
+
+// WouldLimit implements rateLimiter.DryRunRecorder.
+func (t *Telemetry) WouldLimit(tier string, shadow bool) {
+	policy := policyDryRun
+	if shadow {
+		policy = policyShadow
+	}
+	t.wouldLimit.Add(context.Background(), 1, metric.WithAttributes(
+		attribute.String("ratelimit.tier", tier),
+		attribute.String("ratelimit.policy", policy),
+	))
+}
+
+// FailedAttempt implements rateLimiter.SecurityRecorder.
+func (t *Telemetry) FailedAttempt() {
+	t.failedAttempts.Add(context.Background(), 1)
+}
+
+// IPBlocked implements rateLimiter.SecurityRecorder.
+func (t *Telemetry) IPBlocked(time.Duration) {
+	t.blocks.Add(context.Background(), 1)
+}
+
+// checkSpan is the OpenTelemetry span of a rate limit check.
+type checkSpan struct {
+	span trace.Span
+}
+
+// SetPolicy implements rateLimiter.CheckSpan.
+func (s checkSpan) SetPolicy(source string) {
+	s.span.SetAttributes(attribute.String("ratelimit.policy", source))
+}
+
+// SetBucket implements rateLimiter.CheckSpan.
+func (s checkSpan) SetBucket(backend string, fallback bool, remaining int) {
+	s.span.SetAttributes(
+		attribute.String("ratelimit.backend", backend),
+		attribute.Bool("ratelimit.fallback", fallback),
+	)
+	if remaining >= 0 {
+		s.span.SetAttributes(attribute.Int("ratelimit.remaining", remaining))
+	}
+}
+
+// End implements rateLimiter.CheckSpan.
+func (s checkSpan) End(outcome rateLimiter.Outcome, tier string, reason rateLimiter.DecisionReason) {
+	s.span.SetAttributes(
+		attribute.String("ratelimit.decision", string(outcome)),
+		attribute.String("ratelimit.tier", tier),
+		attribute.String("ratelimit.reason", string(reason)),
+	)
+	s.span.End()
+}
+
+// Fail implements rateLimiter.CheckSpan.
+func (s checkSpan) Fail(err error) {
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+	s.span.End()
+}
@@ -0,0 +1,126 @@
+package oteltelemetry_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	rateLimiter "github.com/Popoola-Opeyemi/rateLimiter"
+	"github.com/Popoola-Opeyemi/rateLimiter/oteltelemetry"
+	"github.com/gofiber/fiber/v2"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// newApp returns an app with a limiter traced and measured by telemetry installed
+// with app.Use, answering every path with 200.
+func newApp(t *testing.T, telemetry *oteltelemetry.Telemetry) *fiber.App {
+	t.Helper()
+
+	limiter, err := rateLimiter.NewLimiter(rateLimiter.RateLimiterConfig{
+		DefaultPolicy: rateLimiter.Policy{MaxRequests: 100, BurstCapacity: 100, TokensPerSecond: 1},
+		RoutePolicy: map[string]rateLimiter.Policy{
+			"/api/login": {MaxRequests: 5, BurstCapacity: 5, TokensPerSecond: 1},
+		},
+		KeyPrefix:   "rl",
+		GetUserID:   func(c *fiber.Ctx) string { return "" },
+		GetUserTier: func(c *fiber.Ctx) string { return "free" },
+		Metrics:     telemetry,
+		Tracer:      telemetry,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { limiter.Close() })
+	telemetry.Watch(limiter)
+
+	app := fiber.New()
+	app.Use(limiter.Handler())
+	app.All("/*", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+	return app
+}
+
+func get(t *testing.T, app *fiber.App, path string) {
+	t.Helper()
+
+	resp, err := app.Test(httptest.NewRequest("GET", path, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("GET %s: status %d, want 200", path, resp.StatusCode)
+	}
+}
+
+func TestCheckSpanRoute(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	telemetry, err := oteltelemetry.New(oteltelemetry.Config{
+		TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)),
+		MeterProvider:  sdkmetric.NewMeterProvider(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := newApp(t, telemetry)
+
+	get(t, app, "/api/login")
+	get(t, app, "/api/users")
+
+	// Requests without a route policy are accounted to the middleware's route
+	var routes []string
+	for _, span := range spans.Ended() {
+		if span.Name() != "ratelimit.check" {
+			continue
+		}
+		for _, attr := range span.Attributes() {
+			if attr.Key == "http.route" {
+				routes = append(routes, attr.Value.AsString())
+			}
+		}
+	}
+	if len(routes) != 2 || routes[0] != "/api/login" || routes[1] != "/" {
+		t.Fatalf("check span routes: got %q, want [/api/login /]", routes)
+	}
+}
+
+func TestCloseUnregistersGauges(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	telemetry, err := oteltelemetry.New(oteltelemetry.Config{
+		TracerProvider: sdktrace.NewTracerProvider(),
+		MeterProvider:  sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	get(t, newApp(t, telemetry), "/api/users")
+
+	if !hasMetric(t, reader, "ratelimit.memory.entries") {
+		t.Fatal("ratelimit.memory.entries not observed before Close")
+	}
+	if err := telemetry.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if hasMetric(t, reader, "ratelimit.memory.entries") {
+		t.Fatal("ratelimit.memory.entries still observed after Close")
+	}
+}
+
+// hasMetric reports whether reader collects a metric called name with data points.
+func hasMetric(t *testing.T, reader sdkmetric.Reader, name string) bool {
+	t.Helper()
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	for _, scope := range rm.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			if gauge, ok := m.Data.(metricdata.Gauge[int64]); ok && m.Name == name {
+				return len(gauge.DataPoints) > 0
+			}
+		}
+	}
+	return false
+}
@@ -3,6 +3,9 @@ package rateLimiter
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/netip"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -15,7 +18,8 @@ type SecurityConfig struct {
 	// These should be secure, randomly generated tokens
 	BypassTokens []string
 
-	// WhitelistIPs is a list of IP addresses that are exempt from rate limiting
+	// WhitelistIPs is a list of IP addresses and CIDR ranges (e.g. "10.0.0.0/8") that are
+	// exempt from rate limiting
 	WhitelistIPs []string
 
 	// RequireAuthentication determines if rate limiting should be stricter for unauthenticated requests
@@ -26,6 +30,10 @@ type SecurityConfig struct {
 
 	// BlockDuration is how long to block an IP after exceeding MaxFailedAttempts
 	BlockDuration time.Duration
+
+	// whitelist is WhitelistIPs parsed by the Limiter when the configuration is stored,
+	// nil if it hasn't been
+	whitelist *ipSet
 }
 
 // Policy defines the rate limiting rules for a specific user tier.
@@ -56,6 +64,22 @@ type Policy struct {
 	// WebSocket connections are typically more resource-intensive and may need stricter controls.
 	WebSocketAllowed bool
 
+	// FailureMode determines how requests are handled when the bucket state can't be
+	// read from any storage backend. Defaults to FailClosed.
+	FailureMode FailureMode
+
+	// DryRun evaluates the policy and reports the requests it would reject, in logs,
+	// metrics (see DryRunRecorder) and the X-RateLimit-Would-Limit header, but lets every
+	// request through, including WebSocket upgrades WebSocketAllowed would forbid. Use it
+	// to see who a new or tightened policy would hit before enforcing it.
+	DryRun bool
+
+	// Shadow, if set, is a candidate policy evaluated next to this one on a bucket of its
+	// own. It never rejects requests; the requests it would reject are reported as for
+	// DryRun. Its own DryRun, Shadow, WebSocketAllowed, FailureMode and Security
+	// settings are not used.
+	Shadow *Policy
+
 	// Security contains security-related settings for this policy
 	Security SecurityConfig
 }
@@ -65,10 +89,18 @@ type Policy struct {
 // how user identification should be handled.
 type RateLimiterConfig struct {
 	// Redis is the Redis client used for distributed rate limiting.
+	// Any redis.UniversalClient works: a single-node *redis.Client, a Sentinel failover
+	// client (redis.NewFailoverClient) or a *redis.ClusterClient.
 	// If nil, the rate limiter will use in-memory storage.
 	// In-memory storage is suitable for single-instance applications,
 	// while Redis is recommended for distributed deployments.
-	Redis *redis.Client
+	Redis redis.UniversalClient
+
+	// Storage, if set, is used as the primary storage instead of Redis, for example a
+	// *boltstorage.Storage to keep buckets across restarts on a single node. The in-memory
+	// storage is still used as the fallback. The caller owns Storage and closes it;
+	// Limiter.Close does not.
+	Storage Storage
 
 	// TierPolicy maps user tiers to their respective rate limiting policies.
 	// Each tier can have its own set of rate limiting rules.
@@ -82,6 +114,13 @@ type RateLimiterConfig struct {
 	// your API from abuse by unknown users.
 	DefaultPolicy Policy
 
+	// RoutePolicy maps route paths (as registered with Fiber, e.g. "/api/users/:id",
+	// or literal request paths such as "/api/login") to policies that override the
+	// tier policy for that route. Each route policy has its own buckets, also under app.Use,
+	// where requests without a route policy share the buckets of the middleware's route.
+	// This is useful for endpoints that are more expensive or more sensitive than the rest of the API.
+	RoutePolicy map[string]Policy
+
 	// KeyPrefix is the prefix used for rate limit keys in storage.
 	// This helps prevent key collisions when multiple applications use the same storage.
 	// For example, if KeyPrefix is "rl", the keys will be stored as "rl:user_id:endpoint".
@@ -105,6 +144,88 @@ type RateLimiterConfig struct {
 
 	// GlobalSecurity contains security settings that apply to all requests
 	GlobalSecurity SecurityConfig
+
+	// InMemory configures the in-memory storage used on its own without Redis and as the
+	// fallback with Redis. Set InMemory.MaxEntries to bound its memory use.
+	InMemory InMemoryConfig
+
+	// StorageTimeout is the deadline for each individual storage operation.
+	// Storage calls also inherit the request's context (c.UserContext()), so they are
+	// cancelled when it is. Operations that exceed the deadline fail with a *TimeoutError
+	// and the request falls back to the next storage or the policy's FailureMode.
+	// Zero means no per-operation deadline.
+	StorageTimeout time.Duration
+
+	// CircuitBreaker, if set, wraps the primary storage (Storage or Redis) in a circuit
+	// breaker so that the rate limiter switches to in-memory storage quickly while the
+	// primary is failing or slow, and goes back to it automatically once it recovers.
+	CircuitBreaker *CircuitBreakerConfig
+
+	// Reconcile, if set, replays buckets that were only written to in-memory storage while
+	// the primary storage was unavailable back to it once it recovers. Ignored when
+	// neither Storage nor Redis is set.
+	// The replay loop runs in the background until Limiter.Close is called.
+	Reconcile *ReconcilerConfig
+
+	// Metrics receives measurements from the rate limiter. Optional.
+	Metrics MetricsRecorder
+
+	// Tracer, if set, traces each rate limit check and storage call, for example with
+	// the oteltelemetry package. Optional.
+	Tracer Tracer
+
+	// Events, if set, receives an Event for every request allowed, limited or bypassed,
+	// every IP blocked and every fallback to the in-memory storage. Optional.
+	Events *EventBus
+
+	// Audit, if set, records every request that skips rate limiting because of a bypass
+	// token or a whitelisted IP, and counts the uses of each bypass token. Records are
+	// written to its sinks in the background, after the request is let through. Optional.
+	Audit *AuditLog
+
+	// Logger receives the rate limiter's log records: storage failures and fallbacks,
+	// IP blocks, dry run rejections and configuration reloads. Records carry structured
+	// attributes such as key, ip, tier and error. Defaults to slog.Default().
+	Logger *slog.Logger
+
+	// Logging sets the level of each kind of log record and how often records of the
+	// same kind are written.
+	Logging LoggingConfig
+
+	// HeaderMode selects the rate limit headers added to responses: the X-RateLimit-*
+	// headers (HeadersLegacy, the default), the IETF draft RateLimit and RateLimit-Policy
+	// headers (HeadersIETF), both, or none.
+	HeaderMode HeaderMode
+
+	// OnLimitReached writes the response when a request is rate limited. The Retry-After
+	// and rate limit headers are already set. Defaults to a 429 JSON response with the
+	// error, limit, retry_after and tier.
+	OnLimitReached DecisionHandler
+
+	// OnBlocked writes the response when the client IP is blocked or has to wait after
+	// failed attempts (see SecurityConfig.MaxFailedAttempts). Defaults to a 429 JSON
+	// response with the error and retry_after.
+	OnBlocked DecisionHandler
+
+	// OnWebSocketForbidden writes the response when a WebSocket upgrade is rejected
+	// because the policy doesn't allow WebSockets. Defaults to a 403 JSON response.
+	OnWebSocketForbidden DecisionHandler
+
+	// OnError writes the response when the bucket state couldn't be read and the policy's
+	// FailureMode rejected the request. Defaults to a 503 JSON response.
+	//
+	// ProblemHandler renders RFC 7807 problem details and can be used for any of these hooks.
+	OnError DecisionHandler
+
+	// Clock is the source of the current time for refilling buckets and computing reset
+	// times. It is also passed to the in-memory and Redis storages, the circuit breaker and
+	// the reconciler when the Limiter is created, unless their own configuration sets one;
+	// a custom Storage has to be given the clock itself. Defaults to the system clock.
+	// Tests can use a fake clock from the clocktest package to advance time without sleeping.
+	Clock Clock
+
+	// logger is attached by the Limiter so that sampling state outlives a single request.
+	logger *eventLogger
 }
 
 // ValidateBypassToken checks if a token is valid and returns true if it is
@@ -127,10 +248,73 @@ func (sc *SecurityConfig) ValidateBypassToken(token string) bool {
 	return false
 }
 
-// IsIPWhitelisted checks if an IP address is in the whitelist
+// IsIPWhitelisted checks if an IP address is in the whitelist, either listed itself
+// or in one of the listed CIDR ranges
 func (sc *SecurityConfig) IsIPWhitelisted(ip string) bool {
-	for _, whitelistedIP := range sc.WhitelistIPs {
-		if ip == whitelistedIP {
+	if len(sc.WhitelistIPs) == 0 {
+		return false
+	}
+	whitelist := sc.whitelist
+	if whitelist == nil {
+		whitelist = newIPSet(sc.WhitelistIPs)
+	}
+	return whitelist.contains(ip)
+}
+
+// ipSet is a set of IP addresses and ranges.
+type ipSet struct {
+	prefixes []netip.Prefix
+}
+
+// newIPSet parses entries, IP addresses or CIDR ranges, into an ipSet. Invalid entries
+// are left out; Validate reports them.
+func newIPSet(entries []string) *ipSet {
+	set := &ipSet{prefixes: make([]netip.Prefix, 0, len(entries))}
+	for _, entry := range entries {
+		if prefix, err := parseIPEntry(entry); err == nil {
+			set.prefixes = append(set.prefixes, prefix)
+		}
+	}
+	return set
+}
+
+// parseIPEntry parses a whitelist entry, an IP address or a CIDR range, into the range
+// it matches. It is shared by Validate and newIPSet, so an entry that validates is
+// matched as written. IPv4-mapped IPv6 entries become IPv4 ones, as client addresses
+// do before they are matched, and the host bits of a range are cleared, so
+// "10.0.0.1/8" matches 10.0.0.0/8.
+func parseIPEntry(entry string) (netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(entry); err == nil {
+		// Client addresses never have a zone, so a zoned entry would never match
+		if addr.Zone() != "" {
+			return netip.Prefix{}, fmt.Errorf("%q has an IPv6 zone", entry)
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+
+	prefix, err := netip.ParsePrefix(entry)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("%q is not a valid IP address or CIDR range", entry)
+	}
+	if addr := prefix.Addr(); addr.Is4In6() {
+		if prefix.Bits() < 96 {
+			return netip.Prefix{}, fmt.Errorf("%q covers more than the IPv4-mapped range", entry)
+		}
+		prefix = netip.PrefixFrom(addr.Unmap(), prefix.Bits()-96)
+	}
+	return prefix.Masked(), nil
+}
+
+// contains reports whether ip is one of the set's addresses or in one of its ranges.
+func (s *ipSet) contains(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range s.prefixes {
+		if prefix.Contains(addr) {
 			return true
 		}
 	}
@@ -0,0 +1,56 @@
+package rateLimiter_test
+
+import (
+	"testing"
+
+	rl "github.com/Popoola-Opeyemi/rateLimiter"
+)
+
+func TestIsIPWhitelisted(t *testing.T) {
+	sc := rl.SecurityConfig{WhitelistIPs: []string{
+		"127.0.0.1", "10.0.0.0/8", "2001:db8::/32", "::ffff:192.168.1.0/120", "172.16.5.4/12",
+	}}
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"127.0.0.1", true},
+		{"127.0.0.2", false},
+		{"10.1.2.3", true},
+		{"11.0.0.1", false},
+		{"::ffff:10.0.0.1", true},
+		{"2001:db8::1", true},
+		{"2001:db9::1", false},
+		{"192.168.1.7", true},
+		{"192.168.2.7", false},
+		{"172.31.0.1", true},
+		{"172.32.0.1", false},
+		{"not-an-ip", false},
+	}
+	for _, tt := range tests {
+		if got := sc.IsIPWhitelisted(tt.ip); got != tt.want {
+			t.Errorf("IsIPWhitelisted(%q) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestWhitelistEntriesValidatedAsMatched(t *testing.T) {
+	tests := []struct {
+		entry string
+		valid bool
+	}{
+		{"10.0.0.1", true},
+		{"10.0.0.1/8", true},
+		{"::ffff:10.0.0.0/104", true},
+		{"fe80::1%eth0", false},
+		{"::ffff:0.0.0.0/64", false},
+		{"10.0.0.0/33", false},
+		{"localhost", false},
+	}
+	for _, tt := range tests {
+		sc := rl.SecurityConfig{WhitelistIPs: []string{tt.entry}}
+		if err := sc.Validate(); (err == nil) != tt.valid {
+			t.Errorf("Validate with %q: err = %v, want valid %v", tt.entry, err, tt.valid)
+		}
+	}
+}
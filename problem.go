@@ -0,0 +1,77 @@
+package rateLimiter
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ProblemContentType is the media type of RFC 7807 problem details.
+const ProblemContentType = "application/problem+json"
+
+// ProblemConfig customizes the problem details written by ProblemHandler.
+type ProblemConfig struct {
+	// Types maps a decision reason to the problem type URI identifying it, for example
+	// "https://api.example.com/problems/rate-limited". Reasons without an entry use
+	// "about:blank", whose title is the status text.
+	Types map[DecisionReason]string
+
+	// Codes maps a decision reason to an application error code, sent as the "code"
+	// extension member. Reasons without an entry have no code.
+	Codes map[DecisionReason]string
+
+	// Extend, if set, is called with the problem before it is written to add or change
+	// members.
+	Extend func(c *fiber.Ctx, d Decision, problem map[string]any)
+}
+
+// ProblemHandler returns a DecisionHandler that writes RFC 7807 problem details
+// (application/problem+json). It can be used for any of the rate limiter's hooks:
+//
+//	problems := rateLimiter.ProblemHandler(rateLimiter.ProblemConfig{})
+//	cfg.OnLimitReached = problems
+//	cfg.OnBlocked = problems
+//
+// Besides the standard type, title, status, detail and instance members, the problem
+// has retry_after (seconds), tier, limit and failed_attempts extension members when
+// they apply to the decision.
+func ProblemHandler(cfg ProblemConfig) DecisionHandler {
+	return func(c *fiber.Ctx, d Decision) error {
+		problem := map[string]any{
+			"type":     "about:blank",
+			"title":    http.StatusText(d.Status),
+			"status":   d.Status,
+			"detail":   d.Message(),
+			"instance": c.OriginalURL(),
+		}
+		if uri, ok := cfg.Types[d.Reason]; ok {
+			problem["type"] = uri
+		}
+		if code, ok := cfg.Codes[d.Reason]; ok {
+			problem["code"] = code
+		}
+		if d.RetryAfter > 0 {
+			problem["retry_after"] = d.retryAfterSeconds()
+		}
+		if d.Tier != "" {
+			problem["tier"] = d.Tier
+		}
+		if d.Reason == ReasonRateLimited {
+			problem["limit"] = d.Limit
+		}
+		if d.FailedAttempts > 0 {
+			problem["failed_attempts"] = d.FailedAttempts
+		}
+		if cfg.Extend != nil {
+			cfg.Extend(c, d, problem)
+		}
+
+		body, err := json.Marshal(problem)
+		if err != nil {
+			return err
+		}
+		c.Set(fiber.HeaderContentType, ProblemContentType)
+		return c.Status(d.Status).Send(body)
+	}
+}
@@ -0,0 +1,349 @@
+// Package promcollector exports the rate limiter's measurements as Prometheus metrics.
+// A Collector is passed as RateLimiterConfig.Metrics, watches the Limiter for its
+// storage gauges and is registered like any other collector:
+//
+//	metrics, err := promcollector.New(promcollector.Config{})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//
+//	cfg.Metrics = metrics
+//	limiter, err := rateLimiter.NewLimiter(cfg)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	metrics.Watch(limiter)
+//	prometheus.MustRegister(metrics)
+//
+// It is a separate package so that applications not using it don't depend on the
+// Prometheus client.
+package promcollector
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	rateLimiter "github.com/Popoola-Opeyemi/rateLimiter"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var _ interface {
+	rateLimiter.RequestRecorder
+	rateLimiter.StorageRecorder
+	rateLimiter.DryRunRecorder
+	rateLimiter.SecurityRecorder
+	prometheus.Collector
+} = (*Collector)(nil)
+
+// Labels of the request counters that can be selected with Config.Labels.
+const (
+	LabelTier   = "tier"
+	LabelRoute  = "route"
+	LabelReason = "reason"
+)
+
+// otherRoute is the route label of routes beyond Config.MaxRoutes.
+const otherRoute = "other"
+
+// Policy label values of the would_limit counter.
+const (
+	policyDryRun = "dry-run"
+	policyShadow = "shadow"
+)
+
+// Config defines the metrics exported by a Collector.
+// Zero values are replaced with the defaults noted on each field.
+type Config struct {
+	// Namespace is the prefix of every metric name. Defaults to "ratelimit".
+	Namespace string
+
+	// ConstLabels are added to every metric, for example to tell several limiters apart.
+	ConstLabels prometheus.Labels
+
+	// Labels selects the labels of the request counters besides outcome, any of
+	// LabelTier, LabelRoute and LabelReason. Leaving labels out keeps the number of
+	// series down. Defaults to all three; use an empty, non-nil slice for none.
+	// The storage failure and dry run counters use the tier label if it is selected.
+	// Its values are the limiter's TierPolicy keys, and "default" for other tiers.
+	Labels []string
+
+	// MaxRoutes bounds the number of distinct route label values. Requests to routes
+	// seen after the limit is reached are counted with the route "other". Defaults to 100.
+	MaxRoutes int
+
+	// LatencyBuckets are the buckets, in seconds, of the storage latency histogram.
+	// Defaults to 0.5ms to 1s in powers of two.
+	LatencyBuckets []float64
+}
+
+// withDefaults returns a copy of cfg with zero values replaced by defaults.
+func (cfg Config) withDefaults() Config {
+	if cfg.Namespace == "" {
+		cfg.Namespace = "ratelimit"
+	}
+	if cfg.Labels == nil {
+		cfg.Labels = []string{LabelTier, LabelRoute, LabelReason}
+	}
+	if cfg.MaxRoutes == 0 {
+		cfg.MaxRoutes = 100
+	}
+	if cfg.LatencyBuckets == nil {
+		cfg.LatencyBuckets = prometheus.ExponentialBuckets(0.0005, 2, 12)
+	}
+	return cfg
+}
+
+// problems returns a description of every invalid field in cfg, prefixed with name.
+func (cfg Config) problems(name string) []string {
+	var problems []string
+	for i, label := range cfg.Labels {
+		if label != LabelTier && label != LabelRoute && label != LabelReason {
+			problems = append(problems, fmt.Sprintf("%s.Labels[%d] %q is not one of %q, %q or %q",
+				name, i, label, LabelTier, LabelRoute, LabelReason))
+		}
+	}
+	if cfg.MaxRoutes < 0 {
+		problems = append(problems, fmt.Sprintf("%s.MaxRoutes must not be negative, got %d", name, cfg.MaxRoutes))
+	}
+	return problems
+}
+
+// Collector is a rateLimiter.MetricsRecorder that exports the rate limiter's
+// measurements as Prometheus metrics. It implements prometheus.Collector and every
+// optional recorder interface.
+//
+// It exports, prefixed with the namespace:
+//   - requests_total: requests by outcome (allowed, denied, bypassed, skipped), tier, route and reason
+//   - storage_duration_seconds: storage call latency by backend, operation and result
+//   - storage_fallbacks_total: bucket checks moved to the fallback storage
+//   - storage_failures_total: requests decided by a FailureMode, by tier and mode
+//   - would_limit_total: requests dry-run and shadow policies would have rejected, by tier and policy
+//   - failed_attempts_total and ip_blocks_total: failed attempts recorded and IPs blocked
+//
+// and for the Limiter passed to Watch:
+//   - memory_entries, memory_bytes: size of the in-memory storage
+//   - memory_evictions_total, memory_expired_total: buckets removed from it
+//   - reconcile_pending: buckets only held in memory, waiting to be replayed to the primary
+//   - circuit_state: state of the circuit breaker (0 closed, 1 open, 2 half-open)
+type Collector struct {
+	requests       *prometheus.CounterVec
+	storageLatency *prometheus.HistogramVec
+	fallbacks      prometheus.Counter
+	failures       *prometheus.CounterVec
+	wouldLimit     *prometheus.CounterVec
+	failedAttempts prometheus.Counter
+	blocks         prometheus.Counter
+
+	memoryEntries   *prometheus.Desc
+	memoryBytes     *prometheus.Desc
+	memoryEvictions *prometheus.Desc
+	memoryExpired   *prometheus.Desc
+	pending         *prometheus.Desc
+	circuitState    *prometheus.Desc
+
+	labels    []string
+	maxRoutes int
+
+	mu      sync.Mutex
+	routes  map[string]bool
+	limiter *rateLimiter.Limiter
+}
+
+// New creates a Collector configured by cfg.
+// It returns a *rateLimiter.ValidationError if cfg selects unknown labels.
+func New(cfg Config) (*Collector, error) {
+	if problems := cfg.problems("promcollector.Config"); len(problems) > 0 {
+		return nil, &rateLimiter.ValidationError{Problems: problems}
+	}
+	cfg = cfg.withDefaults()
+
+	// Labels are kept in a fixed order whatever order they were configured in
+	var labels []string
+	for _, label := range []string{LabelTier, LabelRoute, LabelReason} {
+		if slices.Contains(cfg.Labels, label) {
+			labels = append(labels, label)
+		}
+	}
+	var tierLabel []string
+	if slices.Contains(labels, LabelTier) {
+		tierLabel = []string{LabelTier}
+	}
+
+	opts := func(name, help string) prometheus.Opts {
+		return prometheus.Opts{Namespace: cfg.Namespace, Name: name, Help: help, ConstLabels: cfg.ConstLabels}
+	}
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(cfg.Namespace, "", name), help, nil, cfg.ConstLabels)
+	}
+
+	return &Collector{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts(opts("requests_total",
+			"Requests seen by the rate limiter, by outcome.")), append([]string{"outcome"}, labels...)),
+		storageLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   cfg.Namespace,
+			Name:        "storage_duration_seconds",
+			Help:        "Latency of rate limit storage calls.",
+			ConstLabels: cfg.ConstLabels,
+			Buckets:     cfg.LatencyBuckets,
+		}, []string{"backend", "operation", "result"}),
+		fallbacks: prometheus.NewCounter(prometheus.CounterOpts(opts("storage_fallbacks_total",
+			"Bucket checks moved to the fallback storage because the primary storage failed."))),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts(opts("storage_failures_total",
+			"Requests decided by the policy's failure mode because no storage could be read.")),
+			append(slices.Clone(tierLabel), "mode")),
+		wouldLimit: prometheus.NewCounterVec(prometheus.CounterOpts(opts("would_limit_total",
+			"Requests dry-run and shadow policies would have rejected.")),
+			append(slices.Clone(tierLabel), "policy")),
+		failedAttempts: prometheus.NewCounter(prometheus.CounterOpts(opts("failed_attempts_total",
+			"Rate limited requests to authentication endpoints recorded as failed attempts."))),
+		blocks: prometheus.NewCounter(prometheus.CounterOpts(opts("ip_blocks_total",
+			"IPs blocked after too many failed attempts."))),
+
+		memoryEntries:   desc("memory_entries", "Buckets held in the in-memory storage."),
+		memoryBytes:     desc("memory_bytes", "Approximate memory used by the in-memory storage."),
+		memoryEvictions: desc("memory_evictions_total", "Buckets evicted from the in-memory storage to stay within its size limit."),
+		memoryExpired:   desc("memory_expired_total", "Expired buckets removed from the in-memory storage."),
+		pending:         desc("reconcile_pending", "Buckets waiting to be replayed to the primary storage."),
+		circuitState:    desc("circuit_state", "State of the primary storage's circuit breaker: 0 closed, 1 open, 2 half-open."),
+
+		labels:    labels,
+		maxRoutes: cfg.MaxRoutes,
+		routes:    make(map[string]bool),
+	}, nil
+}
+
+// Watch exports the in-memory storage, reconciler and circuit breaker state of l.
+// Only the Limiter passed last is exported.
+func (c *Collector) Watch(l *rateLimiter.Limiter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.limiter = l
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.requests.Describe(ch)
+	c.storageLatency.Describe(ch)
+	c.fallbacks.Describe(ch)
+	c.failures.Describe(ch)
+	c.wouldLimit.Describe(ch)
+	c.failedAttempts.Describe(ch)
+	c.blocks.Describe(ch)
+	ch <- c.memoryEntries
+	ch <- c.memoryBytes
+	ch <- c.memoryEvictions
+	ch <- c.memoryExpired
+	ch <- c.pending
+	ch <- c.circuitState
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.requests.Collect(ch)
+	c.storageLatency.Collect(ch)
+	c.fallbacks.Collect(ch)
+	c.failures.Collect(ch)
+	c.wouldLimit.Collect(ch)
+	c.failedAttempts.Collect(ch)
+	c.blocks.Collect(ch)
+
+	c.mu.Lock()
+	l := c.limiter
+	c.mu.Unlock()
+	if l == nil {
+		return
+	}
+
+	memory := l.MemoryStats()
+	ch <- prometheus.MustNewConstMetric(c.memoryEntries, prometheus.GaugeValue, float64(memory.Entries))
+	ch <- prometheus.MustNewConstMetric(c.memoryBytes, prometheus.GaugeValue, float64(memory.ApproxBytes))
+	ch <- prometheus.MustNewConstMetric(c.memoryEvictions, prometheus.CounterValue, float64(memory.Evictions))
+	ch <- prometheus.MustNewConstMetric(c.memoryExpired, prometheus.CounterValue, float64(memory.Expired))
+	if l.Reconciling() {
+		ch <- prometheus.MustNewConstMetric(c.pending, prometheus.GaugeValue, float64(l.ReconcilerStats().Pending))
+	}
+	if state, ok := l.CircuitState(); ok {
+		ch <- prometheus.MustNewConstMetric(c.circuitState, prometheus.GaugeValue, float64(state))
+	}
+}
+
+// RequestHandled implements rateLimiter.RequestRecorder.
+func (c *Collector) RequestHandled(outcome rateLimiter.Outcome, tier, route string, reason rateLimiter.DecisionReason) {
+	values := make([]string, 0, 1+len(c.labels))
+	values = append(values, string(outcome))
+	for _, label := range c.labels {
+		switch label {
+		case LabelTier:
+			values = append(values, tier)
+		case LabelRoute:
+			values = append(values, c.routeLabel(route))
+		case LabelReason:
+			values = append(values, string(reason))
+		}
+	}
+	c.requests.WithLabelValues(values...).Inc()
+}
+
+// routeLabel returns the label value for route, "other" once MaxRoutes routes have been seen.
+func (c *Collector) routeLabel(route string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.routes[route] {
+		return route
+	}
+	if len(c.routes) >= c.maxRoutes {
+		return otherRoute
+	}
+	c.routes[route] = true
+	return route
+}
+
+// StorageCall implements rateLimiter.StorageRecorder.
+func (c *Collector) StorageCall(backend, op string, latency time.Duration, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	c.storageLatency.WithLabelValues(backend, op, result).Observe(latency.Seconds())
+}
+
+// FallbackUsed implements rateLimiter.StorageRecorder.
+func (c *Collector) FallbackUsed() {
+	c.fallbacks.Inc()
+}
+
+// StorageFailure implements rateLimiter.MetricsRecorder.
+func (c *Collector) StorageFailure(tier string, mode rateLimiter.FailureMode) {
+	c.failures.WithLabelValues(c.withTier(tier, string(mode))...).Inc()
+}
+
+// WouldLimit implements rateLimiter.DryRunRecorder.
+func (c *Collector) WouldLimit(tier string, shadow bool) {
+	policy := policyDryRun
+	if shadow {
+		policy = policyShadow
+	}
+	c.wouldLimit.WithLabelValues(c.withTier(tier, policy)...).Inc()
+}
+
+// FailedAttempt implements rateLimiter.SecurityRecorder.
+func (c *Collector) FailedAttempt() {
+	c.failedAttempts.Inc()
+}
+
+// IPBlocked implements rateLimiter.SecurityRecorder.
+func (c *Collector) IPBlocked(time.Duration) {
+	c.blocks.Inc()
+}
+
+// withTier returns the label values of a counter with an optional tier label followed by value.
+func (c *Collector) withTier(tier, value string) []string {
+	if slices.Contains(c.labels, LabelTier) {
+		return []string{tier, value}
+	}
+	return []string{value}
+}
@@ -0,0 +1,151 @@
+package promcollector_test
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	rateLimiter "github.com/Popoola-Opeyemi/rateLimiter"
+	"github.com/Popoola-Opeyemi/rateLimiter/promcollector"
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newApp returns an app with a limiter reporting to metrics installed with app.Use,
+// answering every path with 200.
+func newApp(t *testing.T, metrics *promcollector.Collector) *fiber.App {
+	t.Helper()
+
+	limiter, err := rateLimiter.NewLimiter(rateLimiter.RateLimiterConfig{
+		DefaultPolicy: rateLimiter.Policy{MaxRequests: 100, BurstCapacity: 100, TokensPerSecond: 1},
+		RoutePolicy: map[string]rateLimiter.Policy{
+			"/api/login": {MaxRequests: 5, BurstCapacity: 5, TokensPerSecond: 1},
+		},
+		KeyPrefix:   "rl",
+		GetUserID:   func(c *fiber.Ctx) string { return "" },
+		GetUserTier: func(c *fiber.Ctx) string { return "free" },
+		Metrics:     metrics,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { limiter.Close() })
+	metrics.Watch(limiter)
+
+	app := fiber.New()
+	app.Use(limiter.Handler())
+	app.All("/*", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+	return app
+}
+
+func get(t *testing.T, app *fiber.App, path string) {
+	t.Helper()
+
+	resp, err := app.Test(httptest.NewRequest("GET", path, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("GET %s: status %d, want 200", path, resp.StatusCode)
+	}
+}
+
+func TestRouteLabelUsesRoutePolicy(t *testing.T) {
+	metrics, err := promcollector.New(promcollector.Config{Labels: []string{promcollector.LabelRoute}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := newApp(t, metrics)
+
+	get(t, app, "/api/login")
+	get(t, app, "/api/login")
+	get(t, app, "/api/users")
+
+	// Requests without a route policy are counted on the middleware's route
+	want := `
+# HELP ratelimit_requests_total Requests seen by the rate limiter, by outcome.
+# TYPE ratelimit_requests_total counter
+ratelimit_requests_total{outcome="allowed",route="/"} 1
+ratelimit_requests_total{outcome="allowed",route="/api/login"} 2
+`
+	if err := testutil.CollectAndCompare(metrics, strings.NewReader(want), "ratelimit_requests_total"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMaxRoutes(t *testing.T) {
+	metrics, err := promcollector.New(promcollector.Config{
+		Labels:    []string{promcollector.LabelRoute},
+		MaxRoutes: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := newApp(t, metrics)
+
+	get(t, app, "/api/login")
+	get(t, app, "/api/users")
+
+	want := `
+# HELP ratelimit_requests_total Requests seen by the rate limiter, by outcome.
+# TYPE ratelimit_requests_total counter
+ratelimit_requests_total{outcome="allowed",route="/api/login"} 1
+ratelimit_requests_total{outcome="allowed",route="other"} 1
+`
+	if err := testutil.CollectAndCompare(metrics, strings.NewReader(want), "ratelimit_requests_total"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewRejectsUnknownLabels(t *testing.T) {
+	_, err := promcollector.New(promcollector.Config{Labels: []string{"user"}, MaxRoutes: -1})
+
+	var invalid *rateLimiter.ValidationError
+	if !errors.As(err, &invalid) || len(invalid.Problems) != 2 {
+		t.Fatalf("New: got %v, want a ValidationError with 2 problems", err)
+	}
+}
+
+func TestTierLabelIsBounded(t *testing.T) {
+	metrics, err := promcollector.New(promcollector.Config{Labels: []string{promcollector.LabelTier}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter, err := rateLimiter.NewLimiter(rateLimiter.RateLimiterConfig{
+		DefaultPolicy: rateLimiter.Policy{MaxRequests: 100, BurstCapacity: 100, TokensPerSecond: 1},
+		TierPolicy: map[string]rateLimiter.Policy{
+			"pro": {MaxRequests: 1000, BurstCapacity: 1000, TokensPerSecond: 10},
+		},
+		KeyPrefix:   "rl",
+		GetUserID:   func(c *fiber.Ctx) string { return "" },
+		GetUserTier: func(c *fiber.Ctx) string { return c.Get("X-User-Tier") },
+		Metrics:     metrics,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { limiter.Close() })
+	app := fiber.New()
+	app.Use(limiter.Handler())
+	app.All("/*", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+
+	for _, tier := range []string{"pro", "tier-1", "tier-2", "tier-3", "tier-4", "tier-5"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-User-Tier", tier)
+		if _, err := app.Test(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Tiers without a TierPolicy entry share one series
+	want := `
+# HELP ratelimit_requests_total Requests seen by the rate limiter, by outcome.
+# TYPE ratelimit_requests_total counter
+ratelimit_requests_total{outcome="allowed",tier="default"} 5
+ratelimit_requests_total{outcome="allowed",tier="pro"} 1
+`
+	if err := testutil.CollectAndCompare(metrics, strings.NewReader(want), "ratelimit_requests_total"); err != nil {
+		t.Fatal(err)
+	}
+}
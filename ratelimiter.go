@@ -3,15 +3,215 @@
 // policies and multiple storage backends.
 //
 // The package implements a token bucket algorithm for rate limiting, which allows for handling
-// traffic bursts while maintaining overall rate limits. It supports both Redis and in-memory
-// storage backends, with automatic fallback to in-memory storage when Redis is unavailable.
+// traffic bursts while maintaining overall rate limits. It supports Redis, in-memory and
+// SQL storage backends, and on-disk (bbolt) and memcached backends in the boltstorage
+// and memcachestorage packages, with automatic fallback to in-memory storage when the
+// primary backend is unavailable. Its measurements can be exported with the
+// promcollector (Prometheus) and oteltelemetry (OpenTelemetry) packages.
 package rateLimiter
 
 import (
+	"sync"
+	"sync/atomic"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/websocket/v2"
+	"github.com/redis/go-redis/v9"
 )
 
+// Limiter holds the storage backends and the active configuration of a rate limiter.
+// The configuration can be replaced at runtime with SetConfig, ReloadFile or one of the
+// watchers; bucket state lives in the storage backends and is kept across swaps.
+type Limiter struct {
+	primary    Storage
+	fallback   *InMemoryStorage
+	reconciler *Reconciler
+
+	config atomic.Pointer[RateLimiterConfig]
+
+	// mu serializes configuration writers so concurrent reloads don't lose updates
+	mu sync.Mutex
+
+	// version is the last config version applied from a RedisConfigSource
+	version int64
+}
+
+// redisClient returns cfg.Redis, or nil if it is unset or holds a nil client pointer,
+// which happens when a nil *redis.Client variable is assigned to the interface field.
+func redisClient(cfg RateLimiterConfig) redis.UniversalClient {
+	switch client := cfg.Redis.(type) {
+	case *redis.Client:
+		if client == nil {
+			return nil
+		}
+	case *redis.ClusterClient:
+		if client == nil {
+			return nil
+		}
+	case *redis.Ring:
+		if client == nil {
+			return nil
+		}
+	}
+	return cfg.Redis
+}
+
+// NewLimiter validates cfg and creates a Limiter for it.
+// The storage backends are chosen once, from cfg.Storage or cfg.Redis, and are not
+// changed by later configuration swaps.
+func NewLimiter(cfg RateLimiterConfig) (*Limiter, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return newLimiter(cfg), nil
+}
+
+// newLimiter creates a Limiter without validating cfg.
+func newLimiter(cfg RateLimiterConfig) *Limiter {
+	inMemory := cfg.InMemory
+	if inMemory.Clock == nil {
+		inMemory.Clock = cfg.Clock
+	}
+	l := &Limiter{fallback: NewInMemoryStorageWithConfig(inMemory)}
+
+	// Initialize primary storage
+	switch client := redisClient(cfg); {
+	case cfg.Storage != nil:
+		l.primary = cfg.Storage
+	case client != nil:
+		l.primary = NewRedisStorageWithConfig(client, RedisConfig{Clock: cfg.Clock})
+	}
+
+	if l.primary != nil {
+		if cfg.CircuitBreaker != nil {
+			breaker := *cfg.CircuitBreaker
+			if breaker.Clock == nil {
+				breaker.Clock = cfg.Clock
+			}
+			if atomicStorage, ok := l.primary.(AtomicStorage); ok {
+				l.primary = NewAtomicCircuitBreakerStorage(atomicStorage, breaker)
+			} else {
+				l.primary = NewCircuitBreakerStorage(l.primary, breaker)
+			}
+		}
+		if cfg.Reconcile != nil {
+			reconcile := *cfg.Reconcile
+			if reconcile.Clock == nil {
+				reconcile.Clock = cfg.Clock
+			}
+			l.reconciler = NewReconciler(l.primary, l.fallback, reconcile)
+		}
+	} else {
+		l.primary = l.fallback
+	}
+
+	l.storeConfig(cfg)
+	return l
+}
+
+// Config returns a copy of the active configuration.
+func (l *Limiter) Config() RateLimiterConfig {
+	return *l.config.Load()
+}
+
+// SetConfig validates cfg and atomically replaces the active configuration.
+// Requests already in flight finish with the configuration they started with.
+// If cfg is invalid, the active configuration is left unchanged.
+func (l *Limiter) SetConfig(cfg RateLimiterConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.storeConfig(cfg)
+	return nil
+}
+
+// storeConfig makes cfg the active configuration, with a logger attached that keeps
+// its sampling state for as long as cfg is active and its IP whitelist parsed.
+// Callers must hold l.mu, except newLimiter.
+func (l *Limiter) storeConfig(cfg RateLimiterConfig) {
+	cfg.logger = newEventLogger(cfg.Logger, cfg.Logging, cfg.Clock)
+	cfg.GlobalSecurity.whitelist = newIPSet(cfg.GlobalSecurity.WhitelistIPs)
+	l.config.Store(&cfg)
+}
+
+// Reconciling reports whether the Limiter replays buckets to the primary storage,
+// that is whether RateLimiterConfig.Reconcile was set.
+func (l *Limiter) Reconciling() bool {
+	return l.reconciler != nil
+}
+
+// ReconcilerStats returns the counters of the Limiter's reconciler.
+// It returns zero stats if reconciliation is not enabled.
+func (l *Limiter) ReconcilerStats() ReconcilerStats {
+	if l.reconciler == nil {
+		return ReconcilerStats{}
+	}
+	return l.reconciler.Stats()
+}
+
+// MemoryStats returns the size and counters of the Limiter's in-memory storage.
+func (l *Limiter) MemoryStats() MemoryStats {
+	return l.fallback.Stats()
+}
+
+// CircuitState returns the state of the circuit breaker around the primary storage.
+// ok is false if RateLimiterConfig.CircuitBreaker is not set.
+func (l *Limiter) CircuitState() (state CircuitState, ok bool) {
+	breaker, ok := circuitBreakerOf(l.primary)
+	if !ok {
+		return CircuitClosed, false
+	}
+	return breaker.State(), true
+}
+
+// Close stops the Limiter's background work. The handler keeps working after Close,
+// but buckets are no longer reconciled and expired in-memory buckets are no longer swept.
+func (l *Limiter) Close() error {
+	if l.reconciler != nil {
+		l.reconciler.Close()
+	}
+	return l.fallback.Close()
+}
+
+// Handler returns the Fiber middleware handler for this Limiter.
+func (l *Limiter) Handler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		cfg := *l.config.Load()
+
+		// Check if path should be skipped
+		for _, path := range cfg.SkipPaths {
+			if c.Path() == path {
+				recordRequest(c, cfg, OutcomeSkipped, "", ReasonSkipPath)
+				return c.Next()
+			}
+		}
+
+		store := bucketStore{
+			primary:    l.primary,
+			fallback:   l.fallback,
+			timeout:    cfg.StorageTimeout,
+			reconciler: l.reconciler,
+			clock:      cfg.Clock,
+			metrics:    cfg.Metrics,
+			tracer:     cfg.Tracer,
+			log:        cfg.log(),
+			events:     cfg.Events,
+		}
+		store = store.forRequest(c, cfg)
+
+		// Special handling for WebSocket upgrade requests
+		if websocket.IsWebSocketUpgrade(c) {
+			return handleWebSocketUpgrade(c, store, cfg)
+		}
+
+		return handleHTTPRequest(c, store, cfg)
+	}
+}
+
 // RateLimiter creates a new rate limiting middleware for Fiber applications.
 // It takes a RateLimiterConfig struct that defines the rate limiting behavior
 // and returns a Fiber middleware handler.
@@ -55,30 +255,33 @@ import (
 //		SkipPaths: []string{"/metrics", "/health"},
 //	}
 //	app.Use(RateLimiter(config))
+//
+// The configuration is fixed for the lifetime of the handler. Use NewLimiter when
+// policies need to be reloaded without a restart.
+//
+// RateLimiter does not validate cfg; use NewRateLimiter to reject invalid
+// configurations at startup instead of failing on the first request.
+//
+// The handler can't be closed, so the in-memory storage's janitor is not started:
+// expired buckets are replaced when next used, and InMemory.MaxEntries still bounds
+// memory. Use NewLimiter and Limiter.Close to run the janitor.
 func RateLimiter(cfg RateLimiterConfig) fiber.Handler {
-	var primaryStorage Storage
-	var fallbackStorage Storage = NewInMemoryStorage()
+	return newLimiter(withoutJanitor(cfg)).Handler()
+}
 
-	// Initialize primary storage
-	if cfg.Redis != nil {
-		primaryStorage = NewRedisStorage(cfg.Redis)
-	} else {
-		primaryStorage = fallbackStorage
+// NewRateLimiter is like RateLimiter but validates cfg first.
+// If the configuration is invalid it returns a *ValidationError listing every problem found.
+func NewRateLimiter(cfg RateLimiterConfig) (fiber.Handler, error) {
+	l, err := NewLimiter(withoutJanitor(cfg))
+	if err != nil {
+		return nil, err
 	}
+	return l.Handler(), nil
+}
 
-	return func(c *fiber.Ctx) error {
-		// Check if path should be skipped
-		for _, path := range cfg.SkipPaths {
-			if c.Path() == path {
-				return c.Next()
-			}
-		}
-
-		// Special handling for WebSocket upgrade requests
-		if websocket.IsWebSocketUpgrade(c) {
-			return HandleWebSocketUpgrade(c, primaryStorage, fallbackStorage, cfg)
-		}
-
-		return HandleHTTPRequest(c, primaryStorage, fallbackStorage, cfg)
-	}
+// withoutJanitor returns a copy of cfg with the in-memory janitor disabled, for
+// handlers that are never closed and would otherwise leak its goroutine.
+func withoutJanitor(cfg RateLimiterConfig) RateLimiterConfig {
+	cfg.InMemory.JanitorInterval = -1
+	return cfg
 }
@@ -0,0 +1,259 @@
+package rateLimiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReconcilerConfig defines how buckets written only to the fallback storage during a
+// primary outage are replayed to the primary. Zero values are replaced with the
+// defaults noted on each field.
+type ReconcilerConfig struct {
+	// MaxPending is the maximum number of buckets waiting to be replayed.
+	// Buckets mutated after the limit is reached are dropped and counted in
+	// ReconcilerStats.Dropped. Defaults to 10000.
+	MaxPending int
+
+	// Interval is how often the reconciler tries to replay pending buckets.
+	// Defaults to 1 second.
+	Interval time.Duration
+
+	// BatchSize is the maximum number of buckets replayed per attempt. Defaults to 500.
+	BatchSize int
+
+	// Timeout is the deadline for each storage operation during a replay.
+	// Defaults to 1 second.
+	Timeout time.Duration
+
+	// Clock is the source of the current time used to refill buckets before replaying them. Defaults to the system clock.
+	Clock Clock
+}
+
+// withDefaults returns a copy of cfg with zero values replaced by defaults.
+func (cfg ReconcilerConfig) withDefaults() ReconcilerConfig {
+	if cfg.MaxPending == 0 {
+		cfg.MaxPending = 10000
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = time.Second
+	}
+	if cfg.BatchSize == 0 {
+		cfg.BatchSize = 500
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = time.Second
+	}
+	cfg.Clock = clockOrSystem(cfg.Clock)
+	return cfg
+}
+
+// problems returns a description of every invalid field in cfg, prefixed with name.
+func (cfg ReconcilerConfig) problems(name string) []string {
+	var problems []string
+	if cfg.MaxPending < 0 {
+		problems = append(problems, fmt.Sprintf("%s.MaxPending must not be negative, got %d", name, cfg.MaxPending))
+	}
+	if cfg.Interval < 0 {
+		problems = append(problems, fmt.Sprintf("%s.Interval must not be negative, got %s", name, cfg.Interval))
+	}
+	if cfg.BatchSize < 0 {
+		problems = append(problems, fmt.Sprintf("%s.BatchSize must not be negative, got %d", name, cfg.BatchSize))
+	}
+	if cfg.Timeout < 0 {
+		problems = append(problems, fmt.Sprintf("%s.Timeout must not be negative, got %s", name, cfg.Timeout))
+	}
+	return problems
+}
+
+// ReconcilerStats reports the activity of a Reconciler.
+type ReconcilerStats struct {
+	// Pending is the number of buckets currently waiting to be replayed
+	Pending int
+
+	// Queued is the total number of buckets queued for replay
+	Queued uint64
+
+	// Dropped is the total number of buckets not queued because MaxPending was reached
+	Dropped uint64
+
+	// Replayed is the total number of buckets successfully written back to the primary
+	Replayed uint64
+
+	// Failed is the total number of replay attempts that failed
+	Failed uint64
+}
+
+// pendingBucket is what the reconciler needs to know about a bucket to replay it.
+type pendingBucket struct {
+	ttl      time.Duration
+	capacity int
+	rate     float64
+}
+
+// Reconciler replays buckets that were only written to the fallback storage while the
+// primary was unavailable. Once the primary accepts writes again, each pending bucket is
+// written back using the more conservative of the two token counts, so an outage never
+// hands out extra requests.
+type Reconciler struct {
+	primary  Storage
+	fallback Storage
+	cfg      ReconcilerConfig
+
+	mu      sync.Mutex
+	pending map[string]pendingBucket
+
+	queued   atomic.Uint64
+	dropped  atomic.Uint64
+	replayed atomic.Uint64
+	failed   atomic.Uint64
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// NewReconciler creates a Reconciler and starts its background replay loop.
+// Call Close to stop it.
+func NewReconciler(primary, fallback Storage, cfg ReconcilerConfig) *Reconciler {
+	r := &Reconciler{
+		primary:  primary,
+		fallback: fallback,
+		cfg:      cfg.withDefaults(),
+		pending:  make(map[string]pendingBucket),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+// Track queues the bucket at key for replay to the primary storage.
+// It reports false if the bucket was dropped because the queue is full.
+func (r *Reconciler) Track(key string, ttl time.Duration, policy Policy) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.pending[key]; !ok {
+		if len(r.pending) >= r.cfg.MaxPending {
+			r.dropped.Add(1)
+			return false
+		}
+		r.queued.Add(1)
+	}
+
+	r.pending[key] = pendingBucket{ttl: ttl, capacity: policy.BurstCapacity, rate: policy.TokensPerSecond}
+	return true
+}
+
+// Stats returns the current counters of the reconciler.
+func (r *Reconciler) Stats() ReconcilerStats {
+	r.mu.Lock()
+	pending := len(r.pending)
+	r.mu.Unlock()
+
+	return ReconcilerStats{
+		Pending:  pending,
+		Queued:   r.queued.Load(),
+		Dropped:  r.dropped.Load(),
+		Replayed: r.replayed.Load(),
+		Failed:   r.failed.Load(),
+	}
+}
+
+// Close stops the replay loop. Buckets still pending are discarded.
+func (r *Reconciler) Close() error {
+	r.once.Do(func() {
+		close(r.stop)
+	})
+	<-r.done
+	return nil
+}
+
+func (r *Reconciler) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			r.replayBatch()
+		}
+	}
+}
+
+// replayBatch replays up to BatchSize pending buckets. It stops at the first failure
+// caused by the primary being unavailable, leaving the rest for the next attempt.
+func (r *Reconciler) replayBatch() {
+	r.mu.Lock()
+	batch := make(map[string]pendingBucket, min(len(r.pending), r.cfg.BatchSize))
+	for key, bucket := range r.pending {
+		if len(batch) >= r.cfg.BatchSize {
+			break
+		}
+		batch[key] = bucket
+	}
+	r.mu.Unlock()
+
+	for key, bucket := range batch {
+		select {
+		case <-r.stop:
+			return
+		default:
+		}
+
+		err := r.replay(key, bucket)
+		if err != nil {
+			r.failed.Add(1)
+			if errors.Is(err, ErrStorageUnavailable) || errors.Is(err, ErrTimeout) {
+				return
+			}
+		} else {
+			r.replayed.Add(1)
+		}
+
+		// Replayed buckets and buckets that can't be replayed at all are done with
+		r.mu.Lock()
+		if r.pending[key] == bucket {
+			delete(r.pending, key)
+		}
+		r.mu.Unlock()
+	}
+}
+
+// replay writes the more conservative of the fallback and primary state of a bucket
+// back to the primary.
+func (r *Reconciler) replay(key string, bucket pendingBucket) error {
+	now := r.cfg.Clock.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.cfg.Timeout)
+	defer cancel()
+
+	tokens, lastUpdate, err := r.fallback.GetBucket(ctx, key)
+	if err != nil {
+		return err
+	}
+	if lastUpdate.IsZero() {
+		// The bucket has expired locally, there is nothing left to replay
+		return nil
+	}
+	tokens = RefillTokens(tokens, lastUpdate, now, bucket.capacity, bucket.rate)
+
+	primaryTokens, primaryUpdate, err := r.primary.GetBucket(ctx, key)
+	if err != nil && !errors.Is(err, ErrCorruptState) {
+		return err
+	}
+	if err == nil && !primaryUpdate.IsZero() {
+		// Other instances may have kept using the primary, take whichever has fewer tokens
+		tokens = min(tokens, RefillTokens(primaryTokens, primaryUpdate, now, bucket.capacity, bucket.rate))
+	}
+
+	return r.primary.UpdateBucket(ctx, key, tokens, bucket.ttl)
+}
@@ -0,0 +1,134 @@
+package rateLimiter
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// ApplySpec validates spec and atomically swaps it into the active configuration.
+// Sections missing from the spec keep their current values. Bucket state is not touched.
+func (l *Limiter) ApplySpec(spec *ConfigSpec) error {
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cfg := spec.Apply(*l.config.Load())
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	l.storeConfig(cfg)
+	return nil
+}
+
+// ReloadFile loads the configuration file at path and applies it.
+// If the file can't be read or is invalid, the active configuration is left unchanged.
+func (l *Limiter) ReloadFile(path string) error {
+	spec, err := LoadConfigFile(path)
+	if err != nil {
+		return err
+	}
+	return l.ApplySpec(spec)
+}
+
+// WatchFile polls the configuration file at path every interval and reloads it
+// when its modification time or size changes. It returns immediately; watching
+// stops when ctx is cancelled.
+//
+// onReload, if not nil, is called after every reload attempt with its result. If the
+// file can't be read, for example while it is being replaced, the error is reported
+// once, and the file is reloaded as soon as it can be read again.
+func (l *Limiter) WatchFile(ctx context.Context, path string, interval time.Duration, onReload func(error)) {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	var modTime time.Time
+	var size int64
+	if info, err := os.Stat(path); err == nil {
+		modTime, size = info.ModTime(), info.Size()
+	}
+	// failing is whether the last poll couldn't read the file, so a file missing for
+	// several polls is only reported once
+	var failing bool
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			info, err := os.Stat(path)
+			if err != nil {
+				if !failing {
+					l.notifyReload(path, onReload, err)
+				}
+				failing = true
+				continue
+			}
+			if !failing && info.ModTime().Equal(modTime) && info.Size() == size {
+				continue
+			}
+			failing = false
+			modTime, size = info.ModTime(), info.Size()
+
+			l.notifyReload(path, onReload, l.ReloadFile(path))
+		}
+	}()
+}
+
+// ReloadOnSignal reloads the configuration file at path whenever one of the given
+// signals is received, syscall.SIGHUP if none are given. It returns immediately;
+// signal handling stops when ctx is cancelled.
+//
+// onReload, if not nil, is called after every reload attempt with its result.
+func (l *Limiter) ReloadOnSignal(ctx context.Context, path string, onReload func(error), sig ...os.Signal) {
+	// signal.Notify without signals would relay every signal, turning SIGINT and
+	// SIGTERM into reloads
+	if len(sig) == 0 {
+		sig = []os.Signal{syscall.SIGHUP}
+	}
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sig...)
+
+	go func() {
+		defer signal.Stop(ch)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ch:
+				l.notifyReload(path, onReload, l.ReloadFile(path))
+			}
+		}
+	}()
+}
+
+// notifyReload logs the result of reloading the configuration file at path and passes
+// it on to onReload.
+func (l *Limiter) notifyReload(path string, onReload func(error), err error) {
+	log := l.Config().log()
+	if err != nil {
+		log.log(context.Background(), LogConfigReloadError, "Rate limiter configuration reload failed",
+			slog.String("source", path), slog.Any("error", err))
+	} else {
+		log.log(context.Background(), LogConfigReload, "Rate limiter configuration reloaded",
+			slog.String("source", path))
+	}
+	if onReload != nil {
+		onReload(err)
+	}
+}
@@ -0,0 +1,64 @@
+package rateLimiter_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	rl "github.com/Popoola-Opeyemi/rateLimiter"
+)
+
+func TestWatchFileReportsMissingFileOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ratelimit.json")
+	write := func(body string) {
+		if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"skip_paths": ["/health"]}`)
+
+	limiter, err := rl.NewLimiter(baseConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { limiter.Close() })
+
+	results := make(chan error, 100)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	limiter.WatchFile(ctx, path, 5*time.Millisecond, func(err error) { results <- err })
+
+	next := func() error {
+		t.Helper()
+		select {
+		case err := <-results:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("no reload reported")
+			return nil
+		}
+	}
+
+	// The file stays missing for many polls but the failure is reported once
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := next(); err == nil {
+		t.Fatal("missing file: got a successful reload, want an error")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if len(results) != 0 {
+		t.Fatalf("%d more results reported while the file was missing, want none", len(results))
+	}
+
+	// The same content coming back is still reloaded, reporting the recovery
+	write(`{"skip_paths": ["/health"]}`)
+	if err := next(); err != nil {
+		t.Fatalf("file restored: got %v, want a successful reload", err)
+	}
+	if skip := limiter.Config().SkipPaths; len(skip) != 1 || skip[0] != "/health" {
+		t.Fatalf("SkipPaths = %q after the reload, want [/health]", skip)
+	}
+}
@@ -0,0 +1,143 @@
+package rateLimiter_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	rl "github.com/Popoola-Opeyemi/rateLimiter"
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// securityRedis answers the GET and TTL commands of the IP block checks from fixed
+// keys, without a server. Any other command fails.
+type securityRedis struct {
+	values map[string]string
+	ttls   map[string]time.Duration
+}
+
+func (r securityRedis) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return nil, errors.New("no server")
+	}
+}
+
+func (r securityRedis) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		return r.answer(cmd)
+	}
+}
+
+func (r securityRedis) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		var first error
+		for _, cmd := range cmds {
+			if err := r.answer(cmd); err != nil && first == nil {
+				first = err
+			}
+		}
+		return first
+	}
+}
+
+func (r securityRedis) answer(cmd redis.Cmder) error {
+	key, _ := cmd.Args()[1].(string)
+	switch cmd := cmd.(type) {
+	case *redis.StringCmd:
+		value, ok := r.values[key]
+		if !ok {
+			cmd.SetErr(redis.Nil)
+			return redis.Nil
+		}
+		cmd.SetVal(value)
+	case *redis.DurationCmd:
+		ttl, ok := r.ttls[key]
+		if !ok {
+			ttl = -2 * time.Second // missing key
+		}
+		cmd.SetVal(ttl)
+	default:
+		cmd.SetErr(errors.New("unexpected command " + cmd.Name()))
+	}
+	return cmd.Err()
+}
+
+// securityApp returns an app whose limiter reads the IP block state from r, answering
+// every path with 200.
+func securityApp(r securityRedis) *fiber.App {
+	client := redis.NewClient(&redis.Options{Addr: "localhost:0"})
+	client.AddHook(r)
+
+	app := fiber.New()
+	app.Use(rl.RateLimiter(rl.RateLimiterConfig{
+		Redis:         client,
+		Storage:       rl.NewInMemoryStorage(),
+		DefaultPolicy: rl.Policy{MaxRequests: 100, BurstCapacity: 100, TokensPerSecond: 1},
+		KeyPrefix:     "rl",
+		GetUserID:     func(c *fiber.Ctx) string { return "" },
+		GetUserTier:   func(c *fiber.Ctx) string { return "" },
+	}))
+	app.All("/*", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+	return app
+}
+
+func TestIPBlockChecks(t *testing.T) {
+	// app.Test requests come from 0.0.0.0
+	const blockKey, failedKey = "rl:blocked:{0.0.0.0}", "rl:failed:{0.0.0.0}"
+
+	for _, tc := range []struct {
+		name       string
+		redis      securityRedis
+		status     int
+		retryAfter string
+	}{
+		{
+			name: "blocked",
+			redis: securityRedis{
+				values: map[string]string{blockKey: "1", failedKey: "6"},
+				ttls:   map[string]time.Duration{blockKey: 10 * time.Minute, failedKey: 24 * time.Hour},
+			},
+			status:     fiber.StatusTooManyRequests,
+			retryAfter: "600",
+		},
+		{
+			// Two failed attempts slow the IP down for 10 seconds after the last one
+			name: "recent failed attempts",
+			redis: securityRedis{
+				values: map[string]string{failedKey: "2"},
+				ttls:   map[string]time.Duration{failedKey: 24*time.Hour - time.Second},
+			},
+			status:     fiber.StatusTooManyRequests,
+			retryAfter: "9",
+		},
+		{
+			name: "old failed attempts",
+			redis: securityRedis{
+				values: map[string]string{failedKey: "2"},
+				ttls:   map[string]time.Duration{failedKey: 24*time.Hour - time.Minute},
+			},
+			status: fiber.StatusOK,
+		},
+		{
+			name:   "no failed attempts",
+			status: fiber.StatusOK,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := securityApp(tc.redis).Test(httptest.NewRequest("GET", "/", nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tc.status {
+				t.Fatalf("status %d, want %d", resp.StatusCode, tc.status)
+			}
+			if got := resp.Header.Get("Retry-After"); got != tc.retryAfter {
+				t.Fatalf("Retry-After = %q, want %q", got, tc.retryAfter)
+			}
+		})
+	}
+}
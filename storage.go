@@ -2,8 +2,11 @@ package rateLimiter
 
 import (
 	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
 	"strconv"
-	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -11,10 +14,16 @@ import (
 
 // Storage defines the interface for rate limit storage backends.
 // Implementations must be safe for concurrent use.
+//
+// Errors should wrap ErrStorageUnavailable when the backend can't be used, ErrCorruptState
+// when a bucket can't be decoded, and be a *TimeoutError (matching ErrTimeout) when the
+// context's deadline is exceeded, so the rate limiter can fall back or reset accordingly.
 type Storage interface {
 	// GetBucket retrieves the current state of a rate limit bucket.
 	// It returns the number of tokens available, the last update time,
 	// and any error that occurred during retrieval.
+	// A bucket that doesn't exist or has expired is returned as zero tokens
+	// with a zero lastUpdate and no error.
 	GetBucket(ctx context.Context, key string) (tokens float64, lastUpdate time.Time, err error)
 
 	// UpdateBucket updates the state of a rate limit bucket.
@@ -22,77 +31,45 @@ type Storage interface {
 	UpdateBucket(ctx context.Context, key string, tokens float64, expiry time.Duration) error
 }
 
-// RedisStorage implements the Storage interface using Redis as the backend.
-// It provides distributed rate limiting capabilities suitable for multi-instance deployments.
-type RedisStorage struct {
-	client *redis.Client
+// AtomicStorage is implemented by storages that can refill a bucket and take a token
+// from it in a single atomic step. The rate limiter prefers it over separate GetBucket
+// and UpdateBucket calls, which let concurrent requests on the same key race.
+type AtomicStorage interface {
+	Storage
+
+	// TakeToken refills the bucket at key at rate tokens per second, capped at capacity,
+	// and takes one token from it if a whole token is available. A bucket that doesn't
+	// exist or has expired starts full. The bucket is stored with the given expiry.
+	// It returns the tokens left in the bucket and whether a token was taken.
+	TakeToken(ctx context.Context, key string, capacity int, rate float64,
+		expiry time.Duration) (tokens float64, allowed bool, err error)
 }
 
-// InMemoryStorage implements the Storage interface using an in-memory map.
-// It provides a simple, fast storage backend suitable for single-instance deployments
-// or as a fallback when Redis is unavailable.
-type InMemoryStorage struct {
-	buckets map[string]*bucketState
-	mutex   sync.RWMutex
+// RedisConfig defines how a RedisStorage records bucket state.
+// Zero values are replaced with the defaults noted on each field.
+type RedisConfig struct {
+	// Clock is the source of the time recorded as a bucket's last update.
+	// Key expiry is still measured by the Redis server. Defaults to the system clock.
+	Clock Clock
 }
 
-// bucketState represents the current state of a rate limit bucket in memory.
-type bucketState struct {
-	tokens     float64
-	lastUpdate time.Time
-	expiry     time.Time
+// RedisStorage implements the Storage interface using Redis as the backend.
+// It provides distributed rate limiting capabilities suitable for multi-instance deployments.
+type RedisStorage struct {
+	client redis.UniversalClient
+	clock  Clock
 }
 
 // NewRedisStorage creates a new Redis-based storage backend.
 // The provided Redis client must be properly configured and connected.
-func NewRedisStorage(client *redis.Client) *RedisStorage {
-	return &RedisStorage{client: client}
-}
-
-// NewInMemoryStorage creates a new in-memory storage backend.
-// This implementation is thread-safe and suitable for single-instance deployments.
-func NewInMemoryStorage() *InMemoryStorage {
-	return &InMemoryStorage{
-		buckets: make(map[string]*bucketState),
-	}
-}
-
-// GetBucket retrieves the current state of a rate limit bucket from memory.
-// If the bucket doesn't exist or has expired, it returns default values.
-func (ims *InMemoryStorage) GetBucket(ctx context.Context, key string) (float64, time.Time, error) {
-	ims.mutex.RLock()
-	defer ims.mutex.RUnlock()
-
-	bucket, exists := ims.buckets[key]
-	if !exists || time.Now().After(bucket.expiry) {
-		return 0, time.Now(), nil
-	}
-
-	return bucket.tokens, bucket.lastUpdate, nil
+// Every operation touches a single key, so Redis Cluster clients are supported.
+func NewRedisStorage(client redis.UniversalClient) *RedisStorage {
+	return NewRedisStorageWithConfig(client, RedisConfig{})
 }
 
-// UpdateBucket updates the state of a rate limit bucket in memory.
-// It also performs periodic cleanup of expired entries when the bucket count exceeds 10000.
-func (ims *InMemoryStorage) UpdateBucket(ctx context.Context, key string, tokens float64, expiry time.Duration) error {
-	ims.mutex.Lock()
-	defer ims.mutex.Unlock()
-
-	// Clean expired entries periodically (simple implementation)
-	if len(ims.buckets) > 10000 { // Arbitrary threshold for cleanup
-		now := time.Now()
-		for k, v := range ims.buckets {
-			if now.After(v.expiry) {
-				delete(ims.buckets, k)
-			}
-		}
-	}
-
-	ims.buckets[key] = &bucketState{
-		tokens:     tokens,
-		lastUpdate: time.Now(),
-		expiry:     time.Now().Add(expiry),
-	}
-	return nil
+// NewRedisStorageWithConfig creates a new Redis-based storage backend configured by cfg.
+func NewRedisStorageWithConfig(client redis.UniversalClient, cfg RedisConfig) *RedisStorage {
+	return &RedisStorage{client: client, clock: clockOrSystem(cfg.Clock)}
 }
 
 // GetBucket retrieves the current state of a rate limit bucket from Redis.
@@ -101,23 +78,28 @@ func (rs *RedisStorage) GetBucket(ctx context.Context, key string) (float64, tim
 	// Get bucket data from Redis
 	data, err := rs.client.HGetAll(ctx, key).Result()
 	if err != nil && err != redis.Nil {
-		return 0, time.Time{}, err
+		return 0, time.Time{}, WrapUnavailable("GetBucket", key, err)
+	}
+
+	// If key doesn't exist, return default values
+	if len(data) == 0 {
+		return 0, time.Time{}, nil
 	}
 
-	// If key doesn't exist or is incomplete, return default values
-	if len(data) == 0 || data["tokens"] == "" || data["lastUpdate"] == "" {
-		return 0, time.Now(), nil
+	// A bucket missing one of its fields was not written by UpdateBucket
+	if data["tokens"] == "" || data["lastUpdate"] == "" {
+		return 0, time.Time{}, WrapCorrupt(key, errors.New("incomplete bucket"))
 	}
 
 	// Parse data
 	tokens, err := strconv.ParseFloat(data["tokens"], 64)
 	if err != nil {
-		return 0, time.Time{}, err
+		return 0, time.Time{}, WrapCorrupt(key, err)
 	}
 
 	lastUpdateUnix, err := strconv.ParseInt(data["lastUpdate"], 10, 64)
 	if err != nil {
-		return 0, time.Time{}, err
+		return 0, time.Time{}, WrapCorrupt(key, err)
 	}
 
 	lastUpdate := time.Unix(0, lastUpdateUnix)
@@ -128,12 +110,38 @@ func (rs *RedisStorage) GetBucket(ctx context.Context, key string) (float64, tim
 // It uses a Redis transaction to ensure atomic updates of the bucket state.
 func (rs *RedisStorage) UpdateBucket(ctx context.Context, key string, tokens float64, expiry time.Duration) error {
 	// Update bucket data in Redis
-	now := time.Now()
+	now := rs.clock.Now()
 	_, err := rs.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
 		pipe.HSet(ctx, key, "tokens", tokens)
 		pipe.HSet(ctx, key, "lastUpdate", now.UnixNano())
 		pipe.Expire(ctx, key, expiry)
 		return nil
 	})
-	return err
+	return WrapUnavailable("UpdateBucket", key, err)
+}
+
+// encodedBucketSize is the size of an encoded bucket: tokens, last update and expiry
+// time, eight bytes each.
+const encodedBucketSize = 24
+
+// EncodeBucket encodes a bucket for storages that keep it as a single binary value,
+// such as a key-value store without a native numeric type.
+func EncodeBucket(tokens float64, lastUpdate, expiresAt time.Time) []byte {
+	v := make([]byte, encodedBucketSize)
+	binary.BigEndian.PutUint64(v[0:], math.Float64bits(tokens))
+	binary.BigEndian.PutUint64(v[8:], uint64(lastUpdate.UnixNano()))
+	binary.BigEndian.PutUint64(v[16:], uint64(expiresAt.UnixNano()))
+	return v
+}
+
+// DecodeBucket decodes a bucket encoded by EncodeBucket. The error wraps ErrCorruptState.
+func DecodeBucket(key string, v []byte) (tokens float64, lastUpdate, expiresAt time.Time, err error) {
+	if len(v) != encodedBucketSize {
+		err = fmt.Errorf("bucket is %d bytes, want %d", len(v), encodedBucketSize)
+		return 0, time.Time{}, time.Time{}, WrapCorrupt(key, err)
+	}
+	tokens = math.Float64frombits(binary.BigEndian.Uint64(v[0:]))
+	lastUpdate = time.Unix(0, int64(binary.BigEndian.Uint64(v[8:])))
+	expiresAt = time.Unix(0, int64(binary.BigEndian.Uint64(v[16:])))
+	return tokens, lastUpdate, expiresAt, nil
 }
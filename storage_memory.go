@@ -0,0 +1,324 @@
+package rateLimiter
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"hash/maphash"
+	"math/bits"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// bucketOverhead is the approximate number of bytes a bucket uses in memory besides
+// its key: the bucketState, its LRU list element and the map entry.
+const bucketOverhead = 160
+
+// InMemoryConfig defines the layout of an InMemoryStorage.
+// Zero values are replaced with the defaults noted on each field.
+type InMemoryConfig struct {
+	// Shards is the number of independently locked partitions the buckets are spread over.
+	// More shards mean less lock contention between requests for different keys.
+	// It is rounded up to a power of two, and negative values are treated as 1.
+	// Defaults to 4 × GOMAXPROCS, at least 16.
+	Shards int
+
+	// MaxEntries bounds the number of buckets kept in memory. Once a shard holds its
+	// share of MaxEntries, adding a bucket evicts the shard's least recently updated one.
+	// The bound is approximate: each shard holds up to MaxEntries/Shards rounded up, so
+	// the storage may hold up to Shards-1 buckets more than MaxEntries, and a shard
+	// starts evicting while others still have room. Zero means unbounded.
+	MaxEntries int
+
+	// JanitorInterval is how often a background goroutine removes expired buckets.
+	// Defaults to 1 minute; a negative value disables the janitor.
+	JanitorInterval time.Duration
+
+	// Clock is the source of the current time for bucket updates and expiry. Defaults to the system clock.
+	Clock Clock
+}
+
+// withDefaults returns a copy of cfg with zero values replaced by defaults.
+func (cfg InMemoryConfig) withDefaults() InMemoryConfig {
+	if cfg.Shards == 0 {
+		cfg.Shards = max(16, 4*runtime.GOMAXPROCS(0))
+	}
+	cfg.Shards = 1 << bits.Len(uint(max(1, cfg.Shards)-1))
+	if cfg.JanitorInterval == 0 {
+		cfg.JanitorInterval = time.Minute
+	}
+	cfg.Clock = clockOrSystem(cfg.Clock)
+	return cfg
+}
+
+// problems returns a description of every invalid field in cfg, prefixed with name.
+func (cfg InMemoryConfig) problems(name string) []string {
+	var problems []string
+	if cfg.Shards < 0 {
+		problems = append(problems, fmt.Sprintf("%s.Shards must not be negative, got %d", name, cfg.Shards))
+	}
+	if cfg.MaxEntries < 0 {
+		problems = append(problems, fmt.Sprintf("%s.MaxEntries must not be negative, got %d", name, cfg.MaxEntries))
+	}
+	return problems
+}
+
+// MemoryStats reports the size and activity of an InMemoryStorage.
+type MemoryStats struct {
+	// Entries is the number of buckets currently held, including expired ones
+	// the janitor hasn't removed yet
+	Entries int
+
+	// ApproxBytes is an estimate of the memory used by the buckets and their keys
+	ApproxBytes int64
+
+	// Evictions is the total number of buckets evicted to stay within MaxEntries
+	Evictions uint64
+
+	// Expired is the total number of expired buckets removed by the janitor
+	Expired uint64
+}
+
+// InMemoryStorage implements the Storage interface using in-memory maps.
+// It provides a simple, fast storage backend suitable for single-instance deployments
+// or as a fallback when Redis is unavailable.
+//
+// Buckets are spread over shards by key hash, each with its own lock, so requests
+// for different keys rarely contend. It implements AtomicStorage.
+//
+// Expired buckets are removed by a background janitor, and MaxEntries bounds memory
+// use by evicting the least recently updated buckets. Call Close to stop the janitor.
+type InMemoryStorage struct {
+	shards      []*memoryShard
+	seed        maphash.Seed
+	maxPerShard int
+	clock       Clock
+
+	evictions atomic.Uint64
+	expired   atomic.Uint64
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// memoryShard is one lock-protected partition of an InMemoryStorage.
+type memoryShard struct {
+	mutex   sync.RWMutex
+	buckets map[string]*bucketState
+
+	// lru orders the keys by last update, most recent first. It is nil when unbounded.
+	lru *list.List
+
+	// keyBytes is the total length of the keys in buckets
+	keyBytes int
+}
+
+// bucketState represents the current state of a rate limit bucket in memory.
+type bucketState struct {
+	tokens     float64
+	lastUpdate time.Time
+	expiry     time.Time
+
+	// elem is the bucket's entry in the shard's LRU list, nil when unbounded
+	elem *list.Element
+}
+
+// NewInMemoryStorage creates a new in-memory storage backend with the default configuration.
+// This implementation is thread-safe and suitable for single-instance deployments.
+// It starts a janitor goroutine that runs until Close is called, so call Close once
+// the storage is no longer used.
+func NewInMemoryStorage() *InMemoryStorage {
+	return NewInMemoryStorageWithConfig(InMemoryConfig{})
+}
+
+// NewInMemoryStorageWithConfig creates a new in-memory storage backend configured by cfg.
+// Unless disabled, it starts a janitor goroutine that runs until Close is called.
+func NewInMemoryStorageWithConfig(cfg InMemoryConfig) *InMemoryStorage {
+	cfg = cfg.withDefaults()
+
+	ims := &InMemoryStorage{
+		shards: make([]*memoryShard, cfg.Shards),
+		seed:   maphash.MakeSeed(),
+		clock:  cfg.Clock,
+		stop:   make(chan struct{}),
+	}
+	if cfg.MaxEntries > 0 {
+		ims.maxPerShard = max(1, (cfg.MaxEntries+cfg.Shards-1)/cfg.Shards)
+	}
+	for i := range ims.shards {
+		ims.shards[i] = &memoryShard{buckets: make(map[string]*bucketState)}
+		if ims.maxPerShard > 0 {
+			ims.shards[i].lru = list.New()
+		}
+	}
+
+	if cfg.JanitorInterval > 0 {
+		go ims.janitor(cfg.JanitorInterval)
+	}
+	return ims
+}
+
+// Close stops the janitor. The storage remains usable, but expired buckets are
+// only replaced, no longer removed.
+func (ims *InMemoryStorage) Close() error {
+	ims.once.Do(func() {
+		close(ims.stop)
+	})
+	return nil
+}
+
+// Stats returns the current size and counters of the storage.
+func (ims *InMemoryStorage) Stats() MemoryStats {
+	stats := MemoryStats{
+		Evictions: ims.evictions.Load(),
+		Expired:   ims.expired.Load(),
+	}
+	for _, shard := range ims.shards {
+		shard.mutex.RLock()
+		stats.Entries += len(shard.buckets)
+		stats.ApproxBytes += int64(shard.keyBytes) + int64(len(shard.buckets))*bucketOverhead
+		shard.mutex.RUnlock()
+	}
+	return stats
+}
+
+// shard returns the shard holding key.
+func (ims *InMemoryStorage) shard(key string) *memoryShard {
+	hash := maphash.String(ims.seed, key)
+	return ims.shards[hash&uint64(len(ims.shards)-1)]
+}
+
+// GetBucket retrieves the current state of a rate limit bucket from memory.
+// If the bucket doesn't exist or has expired, it returns default values.
+// It returns early if ctx is already done.
+func (ims *InMemoryStorage) GetBucket(ctx context.Context, key string) (float64, time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, time.Time{}, WrapTimeout("GetBucket", key, err)
+	}
+
+	shard := ims.shard(key)
+	shard.mutex.RLock()
+	defer shard.mutex.RUnlock()
+
+	bucket, exists := shard.buckets[key]
+	if !exists || ims.clock.Now().After(bucket.expiry) {
+		return 0, time.Time{}, nil
+	}
+
+	return bucket.tokens, bucket.lastUpdate, nil
+}
+
+// UpdateBucket updates the state of a rate limit bucket in memory.
+// It returns early if ctx is already done.
+func (ims *InMemoryStorage) UpdateBucket(ctx context.Context, key string, tokens float64, expiry time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return WrapTimeout("UpdateBucket", key, err)
+	}
+
+	now := ims.clock.Now()
+	shard := ims.shard(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	ims.set(shard, key, tokens, now, expiry)
+	return nil
+}
+
+// TakeToken refills the bucket at key and takes a token from it while holding the
+// bucket's shard lock, so concurrent requests for the same key can't both spend it.
+// It returns early if ctx is already done.
+func (ims *InMemoryStorage) TakeToken(ctx context.Context, key string, capacity int, rate float64,
+	expiry time.Duration) (float64, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, false, WrapTimeout("TakeToken", key, err)
+	}
+
+	now := ims.clock.Now()
+	shard := ims.shard(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	tokens := float64(capacity)
+	if bucket, exists := shard.buckets[key]; exists && !now.After(bucket.expiry) {
+		tokens = RefillTokens(bucket.tokens, bucket.lastUpdate, now, capacity, rate)
+	}
+
+	allowed := tokens >= 1
+	if allowed {
+		tokens--
+	}
+
+	ims.set(shard, key, tokens, now, expiry)
+	return tokens, allowed, nil
+}
+
+// set stores the bucket state, reusing the existing entry if there is one and
+// evicting the least recently updated bucket if the shard is full.
+// The caller must hold the shard's write lock.
+func (ims *InMemoryStorage) set(shard *memoryShard, key string, tokens float64, now time.Time, expiry time.Duration) {
+	bucket, exists := shard.buckets[key]
+	if !exists {
+		if ims.maxPerShard > 0 && len(shard.buckets) >= ims.maxPerShard {
+			shard.remove(shard.lru.Back().Value.(string))
+			ims.evictions.Add(1)
+		}
+
+		bucket = &bucketState{}
+		shard.buckets[key] = bucket
+		shard.keyBytes += len(key)
+		if shard.lru != nil {
+			bucket.elem = shard.lru.PushFront(key)
+		}
+	} else if bucket.elem != nil {
+		shard.lru.MoveToFront(bucket.elem)
+	}
+
+	bucket.tokens = tokens
+	bucket.lastUpdate = now
+	bucket.expiry = now.Add(expiry)
+}
+
+// janitor removes expired buckets every interval until Close is called.
+func (ims *InMemoryStorage) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ims.stop:
+			return
+		case <-ticker.C:
+			ims.removeExpired(ims.clock.Now())
+		}
+	}
+}
+
+// removeExpired removes every bucket that has expired at now, one shard at a time,
+// so requests only wait on the shard being swept.
+func (ims *InMemoryStorage) removeExpired(now time.Time) {
+	for _, shard := range ims.shards {
+		shard.mutex.Lock()
+		for key, bucket := range shard.buckets {
+			if now.After(bucket.expiry) {
+				shard.remove(key)
+				ims.expired.Add(1)
+			}
+		}
+		shard.mutex.Unlock()
+	}
+}
+
+// remove deletes the bucket at key. The caller must hold the shard's write lock.
+func (s *memoryShard) remove(key string) {
+	bucket, exists := s.buckets[key]
+	if !exists {
+		return
+	}
+	if bucket.elem != nil {
+		s.lru.Remove(bucket.elem)
+	}
+	delete(s.buckets, key)
+	s.keyBytes -= len(key)
+}
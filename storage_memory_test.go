@@ -0,0 +1,117 @@
+package rateLimiter_test
+
+import (
+	"context"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	rl "github.com/Popoola-Opeyemi/rateLimiter"
+)
+
+// BenchmarkInMemoryStorage measures checks spread over many keys from parallel
+// goroutines. Run it with -cpu 1,2,4,8 to see throughput scale with GOMAXPROCS; the
+// single shard case shows the contention the sharding removes.
+func BenchmarkInMemoryStorage(b *testing.B) {
+	const keys = 1024
+
+	for _, bc := range []struct {
+		name   string
+		shards int
+	}{
+		{"OneShard", 1},
+		{"Sharded", 0},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			storage := rl.NewInMemoryStorageWithConfig(rl.InMemoryConfig{Shards: bc.shards, JanitorInterval: -1})
+			b.Cleanup(func() { storage.Close() })
+
+			names := make([]string, keys)
+			for i := range names {
+				names[i] = "rl:user" + strconv.Itoa(i) + ":/"
+			}
+
+			b.Run("TakeToken", func(b *testing.B) {
+				var next atomic.Uint64
+				b.RunParallel(func(pb *testing.PB) {
+					ctx := context.Background()
+					i := next.Add(keys / 8)
+					for pb.Next() {
+						i++
+						if _, _, err := storage.TakeToken(ctx, names[i%keys], 100, 100, time.Second); err != nil {
+							b.Fatal(err)
+						}
+					}
+				})
+			})
+
+			b.Run("GetUpdate", func(b *testing.B) {
+				var next atomic.Uint64
+				b.RunParallel(func(pb *testing.PB) {
+					ctx := context.Background()
+					i := next.Add(keys / 8)
+					for pb.Next() {
+						i++
+						key := names[i%keys]
+						tokens, _, err := storage.GetBucket(ctx, key)
+						if err != nil {
+							b.Fatal(err)
+						}
+						if err := storage.UpdateBucket(ctx, key, tokens, time.Second); err != nil {
+							b.Fatal(err)
+						}
+					}
+				})
+			})
+		})
+	}
+}
+
+func TestNegativeShardsUsesOneShard(t *testing.T) {
+	storage := rl.NewInMemoryStorageWithConfig(rl.InMemoryConfig{Shards: -4, JanitorInterval: -1})
+	defer storage.Close()
+
+	if err := storage.UpdateBucket(context.Background(), "key", 3, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if tokens, _, err := storage.GetBucket(context.Background(), "key"); err != nil || tokens != 3 {
+		t.Fatalf("GetBucket = %v, %v; want 3 tokens", tokens, err)
+	}
+}
+
+func TestRateLimiterStartsNoJanitor(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		rl.RateLimiter(baseConfig())
+		if _, err := rl.NewRateLimiter(baseConfig()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Fatalf("%d goroutines left running by handlers that can't be closed", after-before)
+	}
+}
+
+func TestMaxEntriesBoundsEachShard(t *testing.T) {
+	const shards, maxEntries = 4, 10
+	storage := rl.NewInMemoryStorageWithConfig(rl.InMemoryConfig{
+		Shards:          shards,
+		MaxEntries:      maxEntries,
+		JanitorInterval: -1,
+	})
+	defer storage.Close()
+
+	for i := 0; i < 1000; i++ {
+		if err := storage.UpdateBucket(context.Background(), "key"+strconv.Itoa(i), 1, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each shard holds up to 3 buckets, its share of MaxEntries rounded up
+	if stats := storage.Stats(); stats.Entries > maxEntries+shards-1 || stats.Evictions == 0 {
+		t.Fatalf("stats after 1000 keys: %+v, want at most %d entries and some evictions",
+			stats, maxEntries+shards-1)
+	}
+}
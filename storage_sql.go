@@ -0,0 +1,337 @@
+package rateLimiter
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SQLDialect identifies the SQL database behind a SQLStorage.
+type SQLDialect string
+
+const (
+	// DialectPostgres is PostgreSQL 9.5 or later.
+	DialectPostgres SQLDialect = "postgres"
+
+	// DialectSQLite is SQLite 3.35 or later, which added RETURNING.
+	DialectSQLite SQLDialect = "sqlite"
+)
+
+// sqlIdentifier matches the table names SQLStorage accepts, optionally schema-qualified.
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// SQLConfig defines how a SQLStorage uses its database.
+// Zero values are replaced with the defaults noted on each field.
+type SQLConfig struct {
+	// Dialect is the database behind the *sql.DB. Required.
+	Dialect SQLDialect
+
+	// Table is the name of the table holding the buckets. Its schema version is kept
+	// in a second table with the suffix "_schema". Defaults to "rate_limit_buckets".
+	Table string
+
+	// SweepInterval is how often expired buckets are deleted from the table.
+	// Defaults to 1 minute; a negative value disables sweeping.
+	SweepInterval time.Duration
+
+	// Clock is the source of the current time for bucket updates and expiry. Defaults to the system clock.
+	Clock Clock
+}
+
+// withDefaults returns a copy of cfg with zero values replaced by defaults.
+func (cfg SQLConfig) withDefaults() SQLConfig {
+	if cfg.Table == "" {
+		cfg.Table = "rate_limit_buckets"
+	}
+	if cfg.SweepInterval == 0 {
+		cfg.SweepInterval = time.Minute
+	}
+	cfg.Clock = clockOrSystem(cfg.Clock)
+	return cfg
+}
+
+// problems returns a description of every invalid field in cfg.
+func (cfg SQLConfig) problems() []string {
+	var problems []string
+	if cfg.Dialect != DialectPostgres && cfg.Dialect != DialectSQLite {
+		problems = append(problems, fmt.Sprintf("Dialect must be %q or %q, got %q",
+			DialectPostgres, DialectSQLite, cfg.Dialect))
+	}
+	if !sqlIdentifier.MatchString(cfg.Table) {
+		problems = append(problems, fmt.Sprintf("Table must be a plain SQL identifier, got %q", cfg.Table))
+	}
+	return problems
+}
+
+// sqlMigrations are the schema changes applied by SQLStorage, in order. The schema
+// version recorded in the database is the number of migrations applied.
+// Times are stored as Unix nanoseconds so both dialects compare them the same way.
+var sqlMigrations = []string{
+	`CREATE TABLE IF NOT EXISTS {table} (
+		bucket_key  TEXT PRIMARY KEY,
+		tokens      DOUBLE PRECISION NOT NULL,
+		last_update BIGINT NOT NULL,
+		expires_at  BIGINT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS {index}_expires_at ON {table} (expires_at)`,
+}
+
+// SQLStorage implements the Storage interface on top of a database/sql database, so
+// long-window quotas can live next to the rest of an application's data instead of in
+// Redis. PostgreSQL and SQLite are supported; the caller registers the driver and opens
+// the *sql.DB.
+//
+// It implements AtomicStorage: each check is an UPDATE ... RETURNING in a single
+// transaction, so concurrent requests from any number of instances can't overspend a bucket.
+// With SQLite, set a busy timeout on the connection so concurrent writers wait for each
+// other instead of failing.
+//
+// Call Close to stop the sweeper. The *sql.DB is not closed.
+type SQLStorage struct {
+	db      *sql.DB
+	dialect SQLDialect
+	table   string
+	clock   Clock
+
+	// Statements, rendered for the dialect and table once
+	getQuery    string
+	updateQuery string
+	takeQuery   string
+	refillQuery string
+	sweepQuery  string
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// NewSQLStorage creates a SQLStorage, migrates the schema to the current version and
+// starts sweeping expired buckets in the background.
+func NewSQLStorage(ctx context.Context, db *sql.DB, cfg SQLConfig) (*SQLStorage, error) {
+	cfg = cfg.withDefaults()
+	if err := newValidationError(cfg.problems()); err != nil {
+		return nil, err
+	}
+
+	s := &SQLStorage{
+		db:      db,
+		dialect: cfg.Dialect,
+		table:   cfg.Table,
+		clock:   cfg.Clock,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	s.prepareQueries()
+
+	if err := s.Migrate(ctx); err != nil {
+		return nil, err
+	}
+
+	if cfg.SweepInterval > 0 {
+		go s.sweeper(cfg.SweepInterval)
+	} else {
+		close(s.done)
+	}
+	return s, nil
+}
+
+// prepareQueries renders the bucket statements for the dialect and table.
+//
+// Parameters are numbered so they can be reused within a statement: $1 or ?1 is the key,
+// then the current time, the expiry time, the capacity and the refill rate.
+func (s *SQLStorage) prepareQueries() {
+	// The bucket's tokens refilled up to now, capped at the capacity
+	refilled := fmt.Sprintf("%s(CAST({p4} AS DOUBLE PRECISION), {table}.tokens + "+
+		"CAST({p2} - {table}.last_update AS DOUBLE PRECISION) * CAST({p5} AS DOUBLE PRECISION) / 1e9)",
+		s.leastFunc())
+
+	s.getQuery = s.render(`SELECT tokens, last_update FROM {table} WHERE bucket_key = {p1} AND expires_at >= {p2}`)
+
+	s.updateQuery = s.render(`INSERT INTO {table} (bucket_key, tokens, last_update, expires_at)
+		VALUES ({p1}, {p4}, {p2}, {p3})
+		ON CONFLICT (bucket_key) DO UPDATE SET
+			tokens = excluded.tokens, last_update = excluded.last_update, expires_at = excluded.expires_at`)
+
+	// Takes a token from a new, expired or sufficiently refilled bucket. It returns no row
+	// when the bucket exists but has less than one token, which refillQuery then records.
+	s.takeQuery = s.render(`INSERT INTO {table} (bucket_key, tokens, last_update, expires_at)
+		VALUES ({p1}, CAST({p4} AS DOUBLE PRECISION) - 1, {p2}, {p3})
+		ON CONFLICT (bucket_key) DO UPDATE SET
+			tokens = CASE WHEN {table}.expires_at < {p2} THEN CAST({p4} AS DOUBLE PRECISION) - 1
+				ELSE ` + refilled + ` - 1 END,
+			last_update = {p2}, expires_at = {p3}
+		WHERE {table}.expires_at < {p2} OR ` + refilled + ` >= 1
+		RETURNING tokens`)
+
+	s.refillQuery = s.render(`UPDATE {table} SET tokens = ` + refilled + `, last_update = {p2}, expires_at = {p3}
+		WHERE bucket_key = {p1}
+		RETURNING tokens`)
+
+	s.sweepQuery = s.render(`DELETE FROM {table} WHERE expires_at < {p1}`)
+}
+
+// leastFunc returns the dialect's function for the smaller of two values.
+func (s *SQLStorage) leastFunc() string {
+	if s.dialect == DialectSQLite {
+		return "MIN"
+	}
+	return "LEAST"
+}
+
+// render replaces the {table}, {index} and {pN} placeholders in query.
+func (s *SQLStorage) render(query string) string {
+	replacements := []string{
+		"{table}", s.table,
+		"{index}", strings.ReplaceAll(s.table, ".", "_"),
+	}
+	for i := 1; i <= 5; i++ {
+		param := fmt.Sprintf("$%d", i)
+		if s.dialect == DialectSQLite {
+			param = fmt.Sprintf("?%d", i)
+		}
+		replacements = append(replacements, fmt.Sprintf("{p%d}", i), param)
+	}
+	return strings.NewReplacer(replacements...).Replace(query)
+}
+
+// Migrate brings the schema up to the current version. It is called by NewSQLStorage.
+// On PostgreSQL, concurrent migrations from several instances are serialized with an
+// advisory lock.
+func (s *SQLStorage) Migrate(ctx context.Context) error {
+	schemaTable := s.table + "_schema"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("migrate %s: %w", s.table, err)
+	}
+	defer tx.Rollback()
+
+	if s.dialect == DialectPostgres {
+		if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, s.table); err != nil {
+			return fmt.Errorf("migrate %s: %w", s.table, err)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (version INTEGER PRIMARY KEY)`, schemaTable))
+	if err != nil {
+		return fmt.Errorf("migrate %s: %w", s.table, err)
+	}
+
+	var version int
+	err = tx.QueryRowContext(ctx, fmt.Sprintf(`SELECT COALESCE(MAX(version), 0) FROM %s`, schemaTable)).Scan(&version)
+	if err != nil {
+		return fmt.Errorf("migrate %s: %w", s.table, err)
+	}
+	if version > len(sqlMigrations) {
+		return fmt.Errorf("migrate %s: schema version %d is newer than this package supports (%d)",
+			s.table, version, len(sqlMigrations))
+	}
+
+	for i := version; i < len(sqlMigrations); i++ {
+		if _, err := tx.ExecContext(ctx, s.render(sqlMigrations[i])); err != nil {
+			return fmt.Errorf("migrate %s to version %d: %w", s.table, i+1, err)
+		}
+		_, err = tx.ExecContext(ctx, s.render(fmt.Sprintf(
+			`INSERT INTO %s (version) VALUES ({p1}) ON CONFLICT (version) DO NOTHING`, schemaTable)), i+1)
+		if err != nil {
+			return fmt.Errorf("migrate %s to version %d: %w", s.table, i+1, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("migrate %s: %w", s.table, err)
+	}
+	return nil
+}
+
+// Close stops the sweeper. The database handle is left open.
+func (s *SQLStorage) Close() error {
+	s.once.Do(func() {
+		close(s.stop)
+	})
+	<-s.done
+	return nil
+}
+
+// GetBucket retrieves the current state of a rate limit bucket from the database.
+// If the bucket doesn't exist or has expired, it returns default values.
+func (s *SQLStorage) GetBucket(ctx context.Context, key string) (float64, time.Time, error) {
+	var (
+		tokens     float64
+		lastUpdate int64
+	)
+	err := s.db.QueryRowContext(ctx, s.getQuery, key, s.clock.Now().UnixNano()).Scan(&tokens, &lastUpdate)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, time.Time{}, nil
+	}
+	if err != nil {
+		return 0, time.Time{}, WrapUnavailable("GetBucket", key, err)
+	}
+	return tokens, time.Unix(0, lastUpdate), nil
+}
+
+// UpdateBucket updates the state of a rate limit bucket in the database.
+func (s *SQLStorage) UpdateBucket(ctx context.Context, key string, tokens float64, expiry time.Duration) error {
+	now := s.clock.Now()
+	_, err := s.db.ExecContext(ctx, s.updateQuery, key, now.UnixNano(), now.Add(expiry).UnixNano(), tokens)
+	if err != nil {
+		return WrapUnavailable("UpdateBucket", key, err)
+	}
+	return nil
+}
+
+// TakeToken refills the bucket at key and takes a token from it in a single transaction.
+// The bucket's row stays locked until the transaction ends, so concurrent requests for
+// the same key are serialized by the database.
+func (s *SQLStorage) TakeToken(ctx context.Context, key string, capacity int, rate float64,
+	expiry time.Duration) (float64, bool, error) {
+	now := s.clock.Now()
+	args := []any{key, now.UnixNano(), now.Add(expiry).UnixNano(), capacity, rate}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, false, WrapUnavailable("TakeToken", key, err)
+	}
+	defer tx.Rollback()
+
+	var tokens float64
+	allowed := true
+	err = tx.QueryRowContext(ctx, s.takeQuery, args...).Scan(&tokens)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Not enough tokens; record the refill so the next check starts from now
+		allowed = false
+		err = tx.QueryRowContext(ctx, s.refillQuery, args...).Scan(&tokens)
+	}
+	if err != nil {
+		return 0, false, WrapUnavailable("TakeToken", key, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, false, WrapUnavailable("TakeToken", key, err)
+	}
+	return tokens, allowed, nil
+}
+
+// sweeper deletes expired buckets every interval until Close is called.
+func (s *SQLStorage) sweeper(interval time.Duration) {
+	defer close(s.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			s.db.ExecContext(ctx, s.sweepQuery, s.clock.Now().UnixNano())
+			cancel()
+		}
+	}
+}
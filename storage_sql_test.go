@@ -0,0 +1,92 @@
+package rateLimiter_test
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	rl "github.com/Popoola-Opeyemi/rateLimiter"
+	"github.com/Popoola-Opeyemi/rateLimiter/clocktest"
+	"github.com/Popoola-Opeyemi/rateLimiter/storagetest"
+	_ "modernc.org/sqlite"
+)
+
+// openSQLite opens a new SQLite database in a temporary directory, with a busy
+// timeout so concurrent writers wait for each other.
+func openSQLite(tb testing.TB) *sql.DB {
+	tb.Helper()
+
+	path := filepath.Join(tb.TempDir(), "ratelimit.db")
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestSQLStorageSQLite(t *testing.T) {
+	var clock *clocktest.Clock
+	storagetest.RunWithOptions(t, func(tb testing.TB) rl.Storage {
+		clock = clocktest.New(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+		s, err := rl.NewSQLStorage(tb.Context(), openSQLite(tb), rl.SQLConfig{
+			Dialect:       rl.DialectSQLite,
+			SweepInterval: -1,
+			Clock:         clock,
+		})
+		if err != nil {
+			tb.Fatal(err)
+		}
+		tb.Cleanup(func() { s.Close() })
+		return s
+	}, storagetest.Options{
+		Now:     func() time.Time { return clock.Now() },
+		Advance: func(d time.Duration) { clock.Advance(d) },
+	})
+}
+
+func TestCircuitBreakerSQLStorage(t *testing.T) {
+	var clock *clocktest.Clock
+	storagetest.RunWithOptions(t, func(tb testing.TB) rl.Storage {
+		clock = clocktest.New(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+		s, err := rl.NewSQLStorage(tb.Context(), openSQLite(tb), rl.SQLConfig{
+			Dialect:       rl.DialectSQLite,
+			SweepInterval: -1,
+			Clock:         clock,
+		})
+		if err != nil {
+			tb.Fatal(err)
+		}
+		tb.Cleanup(func() { s.Close() })
+		return rl.NewAtomicCircuitBreakerStorage(s, rl.CircuitBreakerConfig{Clock: clock})
+	}, storagetest.Options{
+		Now:     func() time.Time { return clock.Now() },
+		Advance: func(d time.Duration) { clock.Advance(d) },
+	})
+}
+
+func TestSQLStorageKeepsBucketsAcrossMigrations(t *testing.T) {
+	db := openSQLite(t)
+	cfg := rl.SQLConfig{Dialect: rl.DialectSQLite, SweepInterval: -1}
+
+	s, err := rl.NewSQLStorage(t.Context(), db, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateBucket(t.Context(), "rl:user1:/", 7, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	// Opening the storage again migrates an up-to-date schema, which must keep the data
+	s, err = rl.NewSQLStorage(t.Context(), db, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if tokens, _, err := s.GetBucket(t.Context(), "rl:user1:/"); err != nil || tokens != 7 {
+		t.Fatalf("GetBucket after reopening = %v, %v; want 7 tokens", tokens, err)
+	}
+}
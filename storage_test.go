@@ -0,0 +1,61 @@
+package rateLimiter_test
+
+import (
+	"testing"
+	"time"
+
+	rl "github.com/Popoola-Opeyemi/rateLimiter"
+	"github.com/Popoola-Opeyemi/rateLimiter/clocktest"
+	"github.com/Popoola-Opeyemi/rateLimiter/storagetest"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// testStart is the time the fake clocks of the storage tests start at.
+var testStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestInMemoryStorage(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		cfg  rl.InMemoryConfig
+	}{
+		{"Unbounded", rl.InMemoryConfig{}},
+		{"Bounded", rl.InMemoryConfig{MaxEntries: 100000}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var clock *clocktest.Clock
+			storagetest.RunWithOptions(t, func(tb testing.TB) rl.Storage {
+				clock = clocktest.New(testStart)
+				cfg := tc.cfg
+				cfg.Clock = clock
+				s := rl.NewInMemoryStorageWithConfig(cfg)
+				tb.Cleanup(func() { s.Close() })
+				return s
+			}, storagetest.Options{
+				Now:     func() time.Time { return clock.Now() },
+				Advance: func(d time.Duration) { clock.Advance(d) },
+			})
+		})
+	}
+}
+
+func TestRedisStorage(t *testing.T) {
+	var (
+		mr    *miniredis.Miniredis
+		clock *clocktest.Clock
+	)
+	storagetest.RunWithOptions(t, func(tb testing.TB) rl.Storage {
+		mr = miniredis.RunT(tb)
+		clock = clocktest.New(testStart)
+		client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		tb.Cleanup(func() { client.Close() })
+		return rl.NewRedisStorageWithConfig(client, rl.RedisConfig{Clock: clock})
+	}, storagetest.Options{
+		Now: func() time.Time { return clock.Now() },
+		// Buckets are refilled by the storage's clock and expired by the server's
+		Advance: func(d time.Duration) {
+			clock.Advance(d)
+			mr.FastForward(d)
+		},
+	})
+}
@@ -0,0 +1,476 @@
+// Package storagetest provides a conformance suite for rateLimiter.Storage implementations.
+//
+// Call Run from a test in the package implementing the storage, passing a function that
+// returns a new, empty storage:
+//
+//	func TestStorage(t *testing.T) {
+//		storagetest.Run(t, func(tb testing.TB) rateLimiter.Storage {
+//			s := NewMyStorage()
+//			tb.Cleanup(func() { s.Close() })
+//			return s
+//		})
+//	}
+//
+// The suite covers missing buckets, round trips, expiry, independent keys, large values,
+// cancellation and concurrent use, and the token bucket semantics of TakeToken for
+// storages implementing rateLimiter.AtomicStorage. Run it with -race to check that the
+// storage is safe for concurrent use. Benchmark measures the same operations under
+// parallel load.
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	rateLimiter "github.com/Popoola-Opeyemi/rateLimiter"
+)
+
+// Factory returns a new, empty storage. It is called once for every test and benchmark,
+// and should register any cleanup with tb.Cleanup.
+type Factory func(tb testing.TB) rateLimiter.Storage
+
+// Options adjusts the suite to the storage under test.
+type Options struct {
+	// Now returns the storage's current time. Defaults to time.Now. Storages created with
+	// a fake clock, such as the one in the clocktest package, should pass its Now method.
+	Now func() time.Time
+
+	// Advance moves the storage's clock forward by d, to test expiry and refill.
+	// Defaults to time.Sleep. Storages created with a fake clock should pass its Advance
+	// method; storages backed by a simulated server, such as miniredis, its fast-forward
+	// function.
+	Advance func(d time.Duration)
+}
+
+// withDefaults returns a copy of opts with zero values replaced by defaults.
+func (opts Options) withDefaults() Options {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	if opts.Advance == nil {
+		opts.Advance = time.Sleep
+	}
+	return opts
+}
+
+// Run runs the conformance suite against the storages returned by newStorage.
+func Run(t *testing.T, newStorage Factory) {
+	RunWithOptions(t, newStorage, Options{})
+}
+
+// RunWithOptions runs the conformance suite against the storages returned by newStorage,
+// adjusted by opts.
+func RunWithOptions(t *testing.T, newStorage Factory, opts Options) {
+	opts = opts.withDefaults()
+
+	t.Run("MissingBucket", func(t *testing.T) { testMissingBucket(t, newStorage(t)) })
+	t.Run("RoundTrip", func(t *testing.T) { testRoundTrip(t, newStorage(t), opts) })
+	t.Run("Overwrite", func(t *testing.T) { testOverwrite(t, newStorage(t)) })
+	t.Run("IndependentKeys", func(t *testing.T) { testIndependentKeys(t, newStorage(t)) })
+	t.Run("Expiry", func(t *testing.T) { testExpiry(t, newStorage(t), opts) })
+	t.Run("ExpiryRefreshed", func(t *testing.T) { testExpiryRefreshed(t, newStorage(t), opts) })
+	t.Run("LargeValues", func(t *testing.T) { testLargeValues(t, newStorage(t)) })
+	t.Run("Cancellation", func(t *testing.T) { testCancellation(t, newStorage(t)) })
+	t.Run("Deadline", func(t *testing.T) { testDeadline(t, newStorage(t)) })
+	t.Run("ConcurrentUpdates", func(t *testing.T) { testConcurrentUpdates(t, newStorage(t)) })
+
+	t.Run("TakeToken", func(t *testing.T) {
+		if _, ok := newStorage(t).(rateLimiter.AtomicStorage); !ok {
+			t.Skip("storage does not implement AtomicStorage")
+		}
+		atomicStorage := func(t *testing.T) rateLimiter.AtomicStorage {
+			return newStorage(t).(rateLimiter.AtomicStorage)
+		}
+
+		t.Run("StartsFull", func(t *testing.T) { testTakeStartsFull(t, atomicStorage(t)) })
+		t.Run("Exhausts", func(t *testing.T) { testTakeExhausts(t, atomicStorage(t)) })
+		t.Run("Refills", func(t *testing.T) { testTakeRefills(t, atomicStorage(t), opts) })
+		t.Run("CappedAtCapacity", func(t *testing.T) { testTakeCapped(t, atomicStorage(t), opts) })
+		t.Run("VisibleToGetBucket", func(t *testing.T) { testTakeVisible(t, atomicStorage(t)) })
+		t.Run("Concurrent", func(t *testing.T) { testTakeConcurrent(t, atomicStorage(t)) })
+		t.Run("Cancellation", func(t *testing.T) { testTakeCancellation(t, atomicStorage(t)) })
+	})
+}
+
+// timeSlack is how far a stored lastUpdate may be from the time of the write, to allow
+// for backends that store time at a coarser resolution.
+const timeSlack = 10 * time.Millisecond
+
+func testMissingBucket(t *testing.T, s rateLimiter.Storage) {
+	tokens, lastUpdate, err := s.GetBucket(context.Background(), "missing")
+	if err != nil {
+		t.Fatalf("GetBucket of a missing bucket: unexpected error: %v", err)
+	}
+	if tokens != 0 || !lastUpdate.IsZero() {
+		t.Fatalf("GetBucket of a missing bucket = (%v, %v), want (0, zero time)", tokens, lastUpdate)
+	}
+}
+
+func testRoundTrip(t *testing.T, s rateLimiter.Storage, opts Options) {
+	ctx := context.Background()
+
+	before := opts.Now()
+	mustUpdate(t, s, "bucket", 3.5, time.Minute)
+	after := opts.Now()
+
+	tokens, lastUpdate, err := s.GetBucket(ctx, "bucket")
+	if err != nil {
+		t.Fatalf("GetBucket: %v", err)
+	}
+	if tokens != 3.5 {
+		t.Errorf("tokens = %v, want 3.5", tokens)
+	}
+	if lastUpdate.Before(before.Add(-timeSlack)) || lastUpdate.After(after.Add(timeSlack)) {
+		t.Errorf("lastUpdate = %v, want between %v and %v", lastUpdate, before, after)
+	}
+}
+
+func testOverwrite(t *testing.T, s rateLimiter.Storage) {
+	mustUpdate(t, s, "bucket", 5, time.Minute)
+	mustUpdate(t, s, "bucket", 2.25, time.Minute)
+	mustHaveTokens(t, s, "bucket", 2.25)
+}
+
+func testIndependentKeys(t *testing.T, s rateLimiter.Storage) {
+	mustUpdate(t, s, "a", 1, time.Minute)
+	mustUpdate(t, s, "b", 2, time.Minute)
+	mustUpdate(t, s, "a:b", 3, time.Minute)
+
+	mustHaveTokens(t, s, "a", 1)
+	mustHaveTokens(t, s, "b", 2)
+	mustHaveTokens(t, s, "a:b", 3)
+}
+
+func testExpiry(t *testing.T, s rateLimiter.Storage, opts Options) {
+	// Redis and memcached expire keys in whole seconds
+	mustUpdate(t, s, "bucket", 4, time.Second)
+	opts.Advance(2 * time.Second)
+	mustBeMissing(t, s, "bucket")
+}
+
+func testExpiryRefreshed(t *testing.T, s rateLimiter.Storage, opts Options) {
+	mustUpdate(t, s, "bucket", 4, 2*time.Second)
+	opts.Advance(time.Second)
+	mustUpdate(t, s, "bucket", 3, 2*time.Second)
+	opts.Advance(1500 * time.Millisecond)
+
+	// The second write restarted the expiry
+	mustHaveTokens(t, s, "bucket", 3)
+}
+
+func testLargeValues(t *testing.T, s rateLimiter.Storage) {
+	keys := []string{
+		strings.Repeat("k", 1024),
+		"rl:user with spaces:api_v1_users",
+		"rl:{hash}:tag",
+		"rl:ユーザー:ключ",
+	}
+	for i, key := range keys {
+		mustUpdate(t, s, key, float64(i), time.Minute)
+	}
+	for i, key := range keys {
+		mustHaveTokens(t, s, key, float64(i))
+	}
+
+	values := []float64{0, 1e-9, 123456.789, 1e15, math.MaxInt32}
+	for _, value := range values {
+		mustUpdate(t, s, "value", value, time.Minute)
+		mustHaveTokens(t, s, "value", value)
+	}
+
+	// Long-window quotas keep buckets for weeks
+	mustUpdate(t, s, "long", 7, 45*24*time.Hour)
+	mustHaveTokens(t, s, "long", 7)
+}
+
+func testCancellation(t *testing.T, s rateLimiter.Storage) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, _, err := s.GetBucket(ctx, "bucket"); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetBucket with a cancelled context: error = %v, want context.Canceled", err)
+	}
+	if err := s.UpdateBucket(ctx, "bucket", 1, time.Minute); !errors.Is(err, context.Canceled) {
+		t.Errorf("UpdateBucket with a cancelled context: error = %v, want context.Canceled", err)
+	}
+}
+
+func testDeadline(t *testing.T, s rateLimiter.Storage) {
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	if _, _, err := s.GetBucket(ctx, "bucket"); !errors.Is(err, rateLimiter.ErrTimeout) {
+		t.Errorf("GetBucket past its deadline: error = %v, want ErrTimeout", err)
+	}
+	if err := s.UpdateBucket(ctx, "bucket", 1, time.Minute); !errors.Is(err, rateLimiter.ErrTimeout) {
+		t.Errorf("UpdateBucket past its deadline: error = %v, want ErrTimeout", err)
+	}
+}
+
+func testConcurrentUpdates(t *testing.T, s rateLimiter.Storage) {
+	const (
+		workers = 16
+		writes  = 50
+	)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			own := fmt.Sprintf("own:%d", w)
+			for i := 0; i < writes; i++ {
+				value := float64(w*writes + i)
+				if err := s.UpdateBucket(ctx, "shared", value, time.Minute); err != nil {
+					errs <- err
+					return
+				}
+				if err := s.UpdateBucket(ctx, own, value, time.Minute); err != nil {
+					errs <- err
+					return
+				}
+				if _, _, err := s.GetBucket(ctx, "shared"); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("concurrent update: %v", err)
+	}
+
+	// Every worker's own bucket holds its last write
+	for w := 0; w < workers; w++ {
+		mustHaveTokens(t, s, fmt.Sprintf("own:%d", w), float64(w*writes+writes-1))
+	}
+
+	// The shared bucket holds one of the values written, not a mix of several
+	tokens, _, err := s.GetBucket(ctx, "shared")
+	if err != nil {
+		t.Fatalf("GetBucket: %v", err)
+	}
+	if tokens != math.Trunc(tokens) || tokens < 0 || tokens >= workers*writes {
+		t.Errorf("shared bucket holds %v, which no worker wrote", tokens)
+	}
+}
+
+func testTakeStartsFull(t *testing.T, s rateLimiter.AtomicStorage) {
+	tokens, allowed := mustTake(t, s, "bucket", 5, 1)
+	if !allowed || !near(tokens, 4) {
+		t.Fatalf("first TakeToken = (%v, %v), want (4, true)", tokens, allowed)
+	}
+}
+
+func testTakeExhausts(t *testing.T, s rateLimiter.AtomicStorage) {
+	const capacity = 3
+	for i := 0; i < capacity; i++ {
+		if _, allowed := mustTake(t, s, "bucket", capacity, 1e-6); !allowed {
+			t.Fatalf("TakeToken %d of %d was denied", i+1, capacity)
+		}
+	}
+
+	tokens, allowed := mustTake(t, s, "bucket", capacity, 1e-6)
+	if allowed {
+		t.Fatalf("TakeToken from an empty bucket was allowed")
+	}
+	if tokens < 0 || tokens >= 1 {
+		t.Fatalf("TakeToken from an empty bucket left %v tokens, want less than one", tokens)
+	}
+}
+
+func testTakeRefills(t *testing.T, s rateLimiter.AtomicStorage, opts Options) {
+	const rate = 20
+	mustTake(t, s, "bucket", 1, rate)
+	if _, allowed := mustTake(t, s, "bucket", 1, rate); allowed {
+		t.Fatalf("TakeToken right after emptying the bucket was allowed")
+	}
+
+	opts.Advance(2 * time.Second / rate)
+	if _, allowed := mustTake(t, s, "bucket", 1, rate); !allowed {
+		t.Fatalf("TakeToken after the bucket refilled was denied")
+	}
+}
+
+func testTakeCapped(t *testing.T, s rateLimiter.AtomicStorage, opts Options) {
+	mustTake(t, s, "bucket", 2, 1000)
+	opts.Advance(50 * time.Millisecond)
+
+	// 50 tokens were refilled, but the bucket holds at most 2
+	tokens, allowed := mustTake(t, s, "bucket", 2, 1000)
+	if !allowed || tokens > 1 {
+		t.Fatalf("TakeToken = (%v, %v), want at most 1 token left and allowed", tokens, allowed)
+	}
+}
+
+func testTakeVisible(t *testing.T, s rateLimiter.AtomicStorage) {
+	tokens, _ := mustTake(t, s, "bucket", 10, 1e-6)
+
+	stored, lastUpdate, err := s.GetBucket(context.Background(), "bucket")
+	if err != nil {
+		t.Fatalf("GetBucket: %v", err)
+	}
+	if lastUpdate.IsZero() || !near(stored, tokens) {
+		t.Fatalf("GetBucket after TakeToken = (%v, %v), want (%v, non-zero time)", stored, lastUpdate, tokens)
+	}
+}
+
+func testTakeConcurrent(t *testing.T, s rateLimiter.AtomicStorage) {
+	const (
+		capacity = 10
+		workers  = 50
+	)
+	ctx := context.Background()
+
+	var (
+		wg      sync.WaitGroup
+		allowed atomic.Int32
+		failed  atomic.Int32
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, ok, err := s.TakeToken(ctx, "bucket", capacity, 1e-6, time.Minute)
+			if err != nil {
+				failed.Add(1)
+				return
+			}
+			if ok {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if failed.Load() > 0 {
+		t.Fatalf("%d of %d concurrent TakeToken calls failed", failed.Load(), workers)
+	}
+	if allowed.Load() != capacity {
+		t.Fatalf("%d of %d concurrent TakeToken calls were allowed, want exactly %d",
+			allowed.Load(), workers, capacity)
+	}
+}
+
+func testTakeCancellation(t *testing.T, s rateLimiter.AtomicStorage) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, _, err := s.TakeToken(ctx, "bucket", 1, 1, time.Minute); !errors.Is(err, context.Canceled) {
+		t.Errorf("TakeToken with a cancelled context: error = %v, want context.Canceled", err)
+	}
+}
+
+// Benchmark measures the storages returned by newStorage under parallel load, both on a
+// single hot key and spread over many keys. Use -cpu to compare scaling across cores.
+func Benchmark(b *testing.B, newStorage Factory) {
+	b.Run("GetBucket", func(b *testing.B) {
+		s := newStorage(b)
+		benchmarkKeys(b, func(ctx context.Context, key string) error {
+			_, _, err := s.GetBucket(ctx, key)
+			return err
+		})
+	})
+	b.Run("UpdateBucket", func(b *testing.B) {
+		s := newStorage(b)
+		benchmarkKeys(b, func(ctx context.Context, key string) error {
+			return s.UpdateBucket(ctx, key, 1, time.Minute)
+		})
+	})
+	b.Run("TakeToken", func(b *testing.B) {
+		s, ok := newStorage(b).(rateLimiter.AtomicStorage)
+		if !ok {
+			b.Skip("storage does not implement AtomicStorage")
+		}
+		benchmarkKeys(b, func(ctx context.Context, key string) error {
+			_, _, err := s.TakeToken(ctx, key, 100, 100, time.Minute)
+			return err
+		})
+	})
+}
+
+// benchmarkKeys runs op in parallel on one shared key and on a distinct key per operation.
+func benchmarkKeys(b *testing.B, op func(ctx context.Context, key string) error) {
+	ctx := context.Background()
+
+	b.Run("SameKey", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if err := op(ctx, "bench"); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+	})
+
+	b.Run("DistinctKeys", func(b *testing.B) {
+		var next atomic.Uint64
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				key := fmt.Sprintf("bench:%d", next.Add(1)%10000)
+				if err := op(ctx, key); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+	})
+}
+
+func mustUpdate(t *testing.T, s rateLimiter.Storage, key string, tokens float64, expiry time.Duration) {
+	t.Helper()
+	if err := s.UpdateBucket(context.Background(), key, tokens, expiry); err != nil {
+		t.Fatalf("UpdateBucket(%q, %v, %v): %v", key, tokens, expiry, err)
+	}
+}
+
+func mustHaveTokens(t *testing.T, s rateLimiter.Storage, key string, want float64) {
+	t.Helper()
+	tokens, lastUpdate, err := s.GetBucket(context.Background(), key)
+	if err != nil {
+		t.Fatalf("GetBucket(%q): %v", key, err)
+	}
+	if lastUpdate.IsZero() {
+		t.Fatalf("GetBucket(%q): bucket is missing, want %v tokens", key, want)
+	}
+	if tokens != want {
+		t.Fatalf("GetBucket(%q) = %v tokens, want %v", key, tokens, want)
+	}
+}
+
+func mustBeMissing(t *testing.T, s rateLimiter.Storage, key string) {
+	t.Helper()
+	tokens, lastUpdate, err := s.GetBucket(context.Background(), key)
+	if err != nil {
+		t.Fatalf("GetBucket(%q): %v", key, err)
+	}
+	if tokens != 0 || !lastUpdate.IsZero() {
+		t.Fatalf("GetBucket(%q) = (%v, %v), want an expired bucket", key, tokens, lastUpdate)
+	}
+}
+
+func mustTake(t *testing.T, s rateLimiter.AtomicStorage, key string, capacity int, rate float64) (float64, bool) {
+	t.Helper()
+	tokens, allowed, err := s.TakeToken(context.Background(), key, capacity, rate, time.Minute)
+	if err != nil {
+		t.Fatalf("TakeToken(%q): %v", key, err)
+	}
+	return tokens, allowed
+}
+
+// near reports whether a and b differ by less than the refill a slow test could add.
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 0.01
+}
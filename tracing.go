@@ -0,0 +1,113 @@
+package rateLimiter
+
+import (
+	"context"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Tracer traces rate limit checks and storage calls. The oteltelemetry package's
+// Telemetry implements it with OpenTelemetry.
+// Implementations must be safe for concurrent use.
+type Tracer interface {
+	// StartCheck starts the span covering the rate limit decision of a request, as a
+	// child of the span in ctx, and returns the context storage calls should use. route
+	// is the route the request is accounted to, as passed to RequestRecorder.
+	StartCheck(ctx context.Context, route string, websocket bool) (context.Context, CheckSpan)
+
+	// StartStorageCall starts the span of a call to a storage backend, given by its name
+	// (see StorageName) and operation (GetBucket, UpdateBucket or TakeToken). The
+	// returned function is called with the call's error once it returns.
+	StartStorageCall(ctx context.Context, backend, op string) (context.Context, func(error))
+}
+
+// CheckSpan is the span of a request's rate limit check, started by Tracer.StartCheck.
+// Exactly one of End and Fail is called.
+type CheckSpan interface {
+	// SetPolicy records where the request's policy came from, such as "tier pro" or
+	// "route /api/login".
+	SetPolicy(source string)
+
+	// SetBucket records the storage that decided the request, whether it is the fallback
+	// because the primary storage failed, and the tokens left in the bucket, which is
+	// negative if no storage could be read.
+	SetBucket(backend string, fallback bool, remaining int)
+
+	// End records how the request was handled and ends the span. tier is reported as
+	// to a MetricsRecorder.
+	End(outcome Outcome, tier string, reason DecisionReason)
+
+	// Fail ends the span with err, for requests that couldn't be decided.
+	Fail(err error)
+}
+
+// checkSpanKey is the fiber.Ctx local holding the span of a request's rate limit check.
+type checkSpanKey struct{}
+
+// startCheckSpan starts the span covering the rate limit decision for c, if cfg.Tracer
+// is set, and returns the context storage calls should use. The span is ended by
+// recordRequest once the request is decided, before it is passed on.
+func startCheckSpan(c *fiber.Ctx, cfg RateLimiterConfig, websocket bool) context.Context {
+	ctx := c.UserContext()
+	if cfg.Tracer == nil {
+		return ctx
+	}
+
+	route, _ := matchRoute(c, cfg)
+	ctx, span := cfg.Tracer.StartCheck(ctx, route, websocket)
+	c.Locals(checkSpanKey{}, span)
+	return ctx
+}
+
+// checkSpan returns the rate limit check span of c, or nil if there is none.
+func checkSpan(c *fiber.Ctx) CheckSpan {
+	span, _ := c.Locals(checkSpanKey{}).(CheckSpan)
+	return span
+}
+
+// annotatePolicy records the source of the request's policy on the check span of c.
+func annotatePolicy(c *fiber.Ctx, source string) {
+	if span := checkSpan(c); span != nil {
+		span.SetPolicy(source)
+	}
+}
+
+// annotateResult records the state of the checked bucket on the check span of c.
+func annotateResult(c *fiber.Ctx, result bucketResult) {
+	span := checkSpan(c)
+	if span == nil {
+		return
+	}
+	remaining := -1
+	if !result.unknown {
+		remaining = result.remaining()
+	}
+	span.SetBucket(result.backend, result.fallback, remaining)
+}
+
+// endCheckSpan records the decision on the rate limit check span of c and ends it.
+func endCheckSpan(c *fiber.Ctx, outcome Outcome, tier string, reason DecisionReason) {
+	if span := checkSpan(c); span != nil {
+		c.Locals(checkSpanKey{}, nil)
+		span.End(outcome, tier, reason)
+	}
+}
+
+// failCheckSpan ends the rate limit check span of c with err, for requests that
+// couldn't be decided.
+func failCheckSpan(c *fiber.Ctx, err error) {
+	if span := checkSpan(c); span != nil {
+		c.Locals(checkSpanKey{}, nil)
+		span.Fail(err)
+	}
+}
+
+// startStorageSpan starts a span for a call to storage, if tracer is set. The returned
+// function ends it with the call's error.
+func startStorageSpan(ctx context.Context, tracer Tracer, storage Storage,
+	op string) (context.Context, func(error)) {
+	if tracer == nil {
+		return ctx, func(error) {}
+	}
+	return tracer.StartStorageCall(ctx, StorageName(storage), op)
+}
@@ -0,0 +1,147 @@
+package rateLimiter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ValidationError is returned when a configuration is invalid.
+// It lists every problem found rather than stopping at the first one.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid rate limiter config: " + strings.Join(e.Problems, "; ")
+}
+
+// newValidationError returns nil if there are no problems, so callers can return it directly.
+func newValidationError(problems []string) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: problems}
+}
+
+// Validate checks the policy and returns a *ValidationError listing every invalid field.
+func (p Policy) Validate() error {
+	return newValidationError(p.problems("Policy"))
+}
+
+// Validate checks the security settings and returns a *ValidationError listing every invalid field.
+func (sc *SecurityConfig) Validate() error {
+	return newValidationError(sc.problems("SecurityConfig"))
+}
+
+// Validate checks the whole configuration, including every tier and route policy,
+// and returns a *ValidationError listing every problem found.
+func (cfg RateLimiterConfig) Validate() error {
+	var problems []string
+
+	if cfg.GetUserID == nil {
+		problems = append(problems, "GetUserID must not be nil")
+	}
+	if cfg.GetUserTier == nil {
+		problems = append(problems, "GetUserTier must not be nil")
+	}
+
+	problems = append(problems, cfg.DefaultPolicy.problems("DefaultPolicy")...)
+	for _, tier := range sortedPolicyKeys(cfg.TierPolicy) {
+		if tier == "" {
+			problems = append(problems, "TierPolicy: tier name must not be empty")
+		}
+		problems = append(problems, cfg.TierPolicy[tier].problems(fmt.Sprintf("TierPolicy[%q]", tier))...)
+	}
+	for _, route := range sortedPolicyKeys(cfg.RoutePolicy) {
+		if !strings.HasPrefix(route, "/") {
+			problems = append(problems, fmt.Sprintf("RoutePolicy[%q]: route must start with /", route))
+		}
+		problems = append(problems, cfg.RoutePolicy[route].problems(fmt.Sprintf("RoutePolicy[%q]", route))...)
+	}
+
+	for i, path := range cfg.SkipPaths {
+		if !strings.HasPrefix(path, "/") {
+			problems = append(problems, fmt.Sprintf("SkipPaths[%d]: path %q must start with /", i, path))
+		}
+	}
+
+	if !cfg.HeaderMode.valid() {
+		problems = append(problems, fmt.Sprintf("HeaderMode %q is not one of %q, %q, %q or %q",
+			cfg.HeaderMode, HeadersLegacy, HeadersIETF, HeadersBoth, HeadersNone))
+	}
+
+	problems = append(problems, cfg.InMemory.problems("InMemory")...)
+	if cfg.StorageTimeout < 0 {
+		problems = append(problems, fmt.Sprintf("StorageTimeout must not be negative, got %s", cfg.StorageTimeout))
+	}
+
+	problems = append(problems, cfg.GlobalSecurity.problems("GlobalSecurity")...)
+	problems = append(problems, cfg.Logging.problems("Logging")...)
+	if cfg.CircuitBreaker != nil {
+		problems = append(problems, cfg.CircuitBreaker.problems("CircuitBreaker")...)
+	}
+	if cfg.Reconcile != nil {
+		problems = append(problems, cfg.Reconcile.problems("Reconcile")...)
+	}
+	return newValidationError(problems)
+}
+
+// problems returns a description of every invalid field in p, prefixed with name.
+func (p Policy) problems(name string) []string {
+	var problems []string
+	if p.MaxRequests < 0 {
+		problems = append(problems, fmt.Sprintf("%s.MaxRequests must not be negative, got %d", name, p.MaxRequests))
+	}
+	if p.BurstCapacity <= 0 {
+		problems = append(problems, fmt.Sprintf("%s.BurstCapacity must be positive, got %d", name, p.BurstCapacity))
+	}
+	if p.TokensPerSecond <= 0 {
+		problems = append(problems, fmt.Sprintf("%s.TokensPerSecond must be positive, got %g", name, p.TokensPerSecond))
+	}
+	if !p.FailureMode.valid() {
+		problems = append(problems, fmt.Sprintf("%s.FailureMode %q is not one of %q, %q or %q",
+			name, p.FailureMode, FailClosed, FailOpen, FailLocal))
+	}
+	if p.Shadow != nil {
+		if p.Shadow.Shadow != nil {
+			problems = append(problems, fmt.Sprintf("%s.Shadow must not have a shadow policy of its own", name))
+		}
+		problems = append(problems, p.Shadow.problems(name+".Shadow")...)
+	}
+	return append(problems, p.Security.problems(name+".Security")...)
+}
+
+// problems returns a description of every invalid field in sc, prefixed with name.
+func (sc *SecurityConfig) problems(name string) []string {
+	var problems []string
+	if sc.MaxFailedAttempts < 0 {
+		problems = append(problems, fmt.Sprintf("%s.MaxFailedAttempts must not be negative, got %d", name, sc.MaxFailedAttempts))
+	}
+	if sc.BlockDuration < 0 {
+		problems = append(problems, fmt.Sprintf("%s.BlockDuration must not be negative, got %s", name, sc.BlockDuration))
+	}
+	if sc.MaxFailedAttempts > 0 && sc.BlockDuration == 0 {
+		problems = append(problems, fmt.Sprintf("%s.BlockDuration must be set when MaxFailedAttempts is, otherwise blocks never expire", name))
+	}
+	for i, token := range sc.BypassTokens {
+		if token == "" {
+			problems = append(problems, fmt.Sprintf("%s.BypassTokens[%d] must not be empty", name, i))
+		}
+	}
+	for i, ip := range sc.WhitelistIPs {
+		if _, err := parseIPEntry(ip); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.WhitelistIPs[%d]: %v", name, i, err))
+		}
+	}
+	return problems
+}
+
+func sortedPolicyKeys(m map[string]Policy) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,33 @@
+// Package yamlconfig adds YAML configuration files to the rate limiter. Import it for
+// its side effect to make FormatYAML available to ParseConfig, and .yaml and .yml files
+// to LoadConfigFile, Limiter.ReloadFile and the file watchers:
+//
+//	import _ "github.com/Popoola-Opeyemi/rateLimiter/yamlconfig"
+//
+// It is a separate package so that applications configured in JSON or in code don't
+// depend on a YAML parser.
+package yamlconfig
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	rateLimiter "github.com/Popoola-Opeyemi/rateLimiter"
+	"gopkg.in/yaml.v3"
+)
+
+func init() {
+	rateLimiter.RegisterConfigFormat(rateLimiter.FormatYAML, Decode, ".yaml", ".yml")
+}
+
+// Decode decodes a YAML document into spec. Unknown fields are rejected, and an empty
+// document leaves spec unchanged.
+func Decode(data []byte, spec *rateLimiter.ConfigSpec) error {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(spec); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package yamlconfig_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	rateLimiter "github.com/Popoola-Opeyemi/rateLimiter"
+	_ "github.com/Popoola-Opeyemi/rateLimiter/yamlconfig"
+)
+
+const config = `
+tiers:
+  pro:
+    max_requests: 5000
+    burst_capacity: 200
+    tokens_per_second: 5.0
+    websocket_allowed: true
+routes:
+  /api/login:
+    burst_capacity: 5
+    tokens_per_second: 0.1
+security:
+  whitelist_ips: ["127.0.0.1"]
+  max_failed_attempts: 5
+  block_duration: 15m
+`
+
+func TestLoadConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ratelimit.yml")
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	spec, err := rateLimiter.LoadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := spec.Tiers["pro"]; p.BurstCapacity != 200 || !p.WebSocketAllowed {
+		t.Errorf("tiers.pro = %+v", p)
+	}
+	if p := spec.Routes["/api/login"]; p.TokensPerSecond != 0.1 {
+		t.Errorf("routes./api/login = %+v", p)
+	}
+	if d := time.Duration(spec.Security.BlockDuration); d != 15*time.Minute {
+		t.Errorf("security.block_duration = %s, want 15m", d)
+	}
+}
+
+func TestParseConfigRejectsUnknownFields(t *testing.T) {
+	if _, err := rateLimiter.ParseConfig([]byte("tierz: {}\n"), rateLimiter.FormatYAML); err == nil {
+		t.Fatal("ParseConfig accepted an unknown field")
+	}
+}
+
+func TestParseConfigEmptyDocument(t *testing.T) {
+	spec, err := rateLimiter.ParseConfig(nil, rateLimiter.FormatYAML)
+	if err != nil || spec.Tiers != nil || spec.Security != nil {
+		t.Fatalf("ParseConfig(empty) = %+v, %v; want an empty spec", spec, err)
+	}
+}